@@ -0,0 +1,71 @@
+package esatompub
+
+import (
+	"context"
+	"database/sql"
+)
+
+const (
+	sqlSelectAggregate = `select id, feedid, event_time, aggregate_id, version, typecode, payload from t_aeae_atom_event where aggregate_id = :1`
+)
+
+func aggregateQuery(aggregateID string, options queryOptions) (string, []interface{}) {
+	args := bindArgs{aggregateID}
+	query := sqlSelectAggregate
+
+	if options.fromVersion > 0 {
+		query += " and version >= " + args.add(options.fromVersion)
+	}
+
+	if options.toVersion > 0 {
+		query += " and version <= " + args.add(options.toVersion)
+	}
+
+	query += args.typeCodeFilter(options.typeCodes)
+	query += " order by version"
+	query += args.pageClause(options)
+
+	return query, args
+}
+
+// RetrieveAggregateEvents returns the published events for an aggregate, ordered by version.
+func RetrieveAggregateEvents(db *sql.DB, aggregateID string, opts ...QueryOption) ([]TimestampedEvent, error) {
+	return RetrieveAggregateEventsContext(context.Background(), db, aggregateID, opts...)
+}
+
+// RetrieveAggregateEventsContext is like RetrieveAggregateEvents, but the query is bound to ctx.
+func RetrieveAggregateEventsContext(ctx context.Context, db *sql.DB, aggregateID string, opts ...QueryOption) ([]TimestampedEvent, error) {
+	return newStore(db).RetrieveAggregateEventsContext(ctx, aggregateID, opts...)
+}
+
+// RetrieveAggregateEventsContext returns the published events for an aggregate, ordered by version.
+func (s *Store) RetrieveAggregateEventsContext(ctx context.Context, aggregateID string, opts ...QueryOption) (events []TimestampedEvent, err error) {
+	o := applyQueryOptions(opts)
+
+	ctx, span := s.startSpan(ctx, "RetrieveAggregateEvents",
+		AttributeAggregateID.String(aggregateID),
+		AttributeTypeCode.StringSlice(o.typeCodes),
+	)
+	defer func() { endSpan(span, err) }()
+
+	query, args := aggregateQuery(aggregateID, o)
+	return s.retrieveEvents(ctx, query, args...)
+}
+
+// IterateAggregateEvents returns an iterator over the published events for an aggregate,
+// ordered by version.
+func IterateAggregateEvents(db *sql.DB, aggregateID string, opts ...QueryOption) (*EventIterator, error) {
+	return IterateAggregateEventsContext(context.Background(), db, aggregateID, opts...)
+}
+
+// IterateAggregateEventsContext is like IterateAggregateEvents, but the query is bound to ctx.
+func IterateAggregateEventsContext(ctx context.Context, db *sql.DB, aggregateID string, opts ...QueryOption) (*EventIterator, error) {
+	return newStore(db).IterateAggregateEventsContext(ctx, aggregateID, opts...)
+}
+
+// IterateAggregateEventsContext returns an iterator over the published events for an aggregate,
+// ordered by version.
+func (s *Store) IterateAggregateEventsContext(ctx context.Context, aggregateID string, opts ...QueryOption) (*EventIterator, error) {
+	query, args := aggregateQuery(aggregateID, applyQueryOptions(opts))
+	return s.iterateEvents(ctx, query, args...)
+}
@@ -0,0 +1,75 @@
+package esatompub
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"testing"
+	"time"
+)
+
+func TestAggregateQueryNoOptions(t *testing.T) {
+	query, args := aggregateQuery("agg1", applyQueryOptions(nil))
+	assert.Equal(t, sqlSelectAggregate+" order by version", query)
+	assert.Equal(t, []interface{}{"agg1"}, args)
+}
+
+func TestAggregateQueryAllOptions(t *testing.T) {
+	query, args := aggregateQuery("agg1",
+		applyQueryOptions([]QueryOption{WithVersionRange(2, 5), WithLimit(3)}))
+	assert.Equal(t, sqlSelectAggregate+" and version >= :2 and version <= :3 order by version fetch next :4 rows only", query)
+	assert.Equal(t, []interface{}{"agg1", 2, 5, 3}, args)
+}
+
+func TestAggregateQueryTypeCodesAndPaging(t *testing.T) {
+	query, args := aggregateQuery("agg1",
+		applyQueryOptions([]QueryOption{WithTypeCodes("foo", "bar"), WithOffset(10), WithLimit(5)}))
+	assert.Equal(t, sqlSelectAggregate+" and typecode in (:2, :3) order by version offset :4 rows fetch next :5 rows only", query)
+	assert.Equal(t, []interface{}{"agg1", "foo", "bar", 10, 5}, args)
+}
+
+func TestAggregateQueryOpenLowerBound(t *testing.T) {
+	query, args := aggregateQuery("agg1", applyQueryOptions([]QueryOption{WithVersionRange(0, 5)}))
+	assert.Equal(t, sqlSelectAggregate+" and version <= :2 order by version", query)
+	assert.Equal(t, []interface{}{"agg1", 5}, args)
+}
+
+func TestRetrieveAggregateEvents(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	ts := time.Now()
+	rows := sqlmock.NewRows([]string{"id", "feedid", "event_time", "aggregate_id",
+		"version", "typecode", "payload"},
+	).AddRow(int64(1), nil, ts, "agg1", 2, "foo", []byte("two")).
+		AddRow(int64(2), nil, ts, "agg1", 3, "bar", []byte("three"))
+	mock.ExpectQuery("select .* where aggregate_id = :1 and version >= :2 order by version").
+		WithArgs("agg1", 2).WillReturnRows(rows)
+
+	events, err := RetrieveAggregateEvents(db, "agg1", WithVersionRange(2, 0))
+	if assert.Nil(t, err) {
+		assert.Nil(t, mock.ExpectationsWereMet())
+		if assert.Equal(t, 2, len(events)) {
+			assert.Equal(t, 2, events[0].Version)
+			assert.Equal(t, "bar", events[1].TypeCode)
+		}
+	}
+}
+
+func TestRetrieveAggregateEventsError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("select").WithArgs("agg1").WillReturnError(errors.New("boom"))
+
+	_, err = RetrieveAggregateEvents(db, "agg1")
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "boom", err.Error())
+	}
+}
@@ -1,6 +1,7 @@
 package esatompub
 
 import (
+	"context"
 	"database/sql"
 	"github.com/xtracdev/goes"
 	"time"
@@ -9,76 +10,110 @@ import (
 type TimestampedEvent struct {
 	goes.Event
 	Timestamp time.Time
+	Sequence  int64  //Position of the event in the overall event history
+	FeedID    string //Archive feed holding the event, empty for recent events
 }
 
 const (
-	sqlSelectRecent       = `select event_time, aggregate_id, version, typecode, payload from t_aeae_atom_event where feedid is null order by id desc`
-	sqlSelectForFeed      = `select event_time, aggregate_id, version, typecode, payload from t_aeae_atom_event where feedid = :1 order by id desc`
+	sqlSelectRecent       = `select id, feedid, event_time, aggregate_id, version, typecode, payload from t_aeae_atom_event where feedid is null order by id desc`
+	sqlSelectForFeed      = `select id, feedid, event_time, aggregate_id, version, typecode, payload from t_aeae_atom_event where feedid = :1 order by id desc`
 	sqlSelectPreviousFeed = `select previous from t_aefd_feed where feedid = :1`
 	sqlSelectNextFeed     = `select feedid from t_aefd_feed where previous = :1`
-	sqlSelectEvent        = `select event_time, typecode, payload from t_aeae_atom_event where aggregate_id = :1 and version = :2`
+	sqlSelectEvent        = `select id, feedid, event_time, typecode, payload from t_aeae_atom_event where aggregate_id = :1 and version = :2`
+	sqlFeedExists         = `select count(*) from t_aefd_feed where feedid = :1`
+	sqlSelectFirstFeed    = `select feedid from t_aefd_feed where id = (select min(id) from t_aefd_feed where previous is null)`
 )
 
 func RetrieveRecent(db *sql.DB) ([]TimestampedEvent, error) {
-	return retrieveEvents(db, sqlSelectRecent, "")
+	return RetrieveRecentContext(context.Background(), db)
 }
 
+// RetrieveRecentContext is like RetrieveRecent, but the query is bound to ctx.
+func RetrieveRecentContext(ctx context.Context, db *sql.DB) ([]TimestampedEvent, error) {
+	return newStore(db).RetrieveRecentContext(ctx)
+}
+
+// RetrieveRecentContext returns the recent events, newest first.
+func (s *Store) RetrieveRecentContext(ctx context.Context) (events []TimestampedEvent, err error) {
+	ctx, span := s.startSpan(ctx, "RetrieveRecent")
+	defer func() { endSpan(span, err) }()
+
+	return s.retrieveEvents(ctx, sqlSelectRecent)
+}
+
+// RetrieveArchive returns the events in the given archive feed, or ErrFeedNotFound
+// if there is no such feed.
 func RetrieveArchive(db *sql.DB, feedid string) ([]TimestampedEvent, error) {
-	return retrieveEvents(db, sqlSelectForFeed, feedid)
+	return RetrieveArchiveContext(context.Background(), db, feedid)
 }
 
-func retrieveEvents(db *sql.DB, query string, feedid string) ([]TimestampedEvent, error) {
-	var events []TimestampedEvent
+// RetrieveArchiveContext is like RetrieveArchive, but the query is bound to ctx.
+func RetrieveArchiveContext(ctx context.Context, db *sql.DB, feedid string) ([]TimestampedEvent, error) {
+	return newStore(db).RetrieveArchiveContext(ctx, feedid)
+}
 
-	var rows *sql.Rows
-	var err error
-	if feedid == "" {
-		rows, err = db.Query(query)
-	} else {
-		rows, err = db.Query(query, feedid)
+// RetrieveArchiveContext returns the events in the given archive feed, newest first,
+// or ErrFeedNotFound if there is no such feed.
+func (s *Store) RetrieveArchiveContext(ctx context.Context, feedid string) (events []TimestampedEvent, err error) {
+	ctx, span := s.startSpan(ctx, "RetrieveArchive", AttributeFeedID.String(feedid))
+	defer func() { endSpan(span, err) }()
+
+	events, err = s.retrieveEvents(ctx, sqlSelectForFeed, feedid)
+	if err != nil || len(events) > 0 {
+		return events, err
 	}
 
+	//No events - figure out if it's an empty feed or no feed at all
+	exists, err := s.feedExists(ctx, feedid)
 	if err != nil {
 		return events, err
 	}
 
-	defer rows.Close()
-
-	var eventTime time.Time
-	var aggregateId, typecode string
-	var version int
-	var payload []byte
+	if !exists {
+		return events, ErrFeedNotFound
+	}
 
-	for rows.Next() {
-		err := rows.Scan(&eventTime, &aggregateId, &version, &typecode, &payload)
-		if err != nil {
-			return events, err
-		}
+	return events, nil
+}
 
-		event := TimestampedEvent{
-			Event: goes.Event{
-				Source:   aggregateId,
-				Version:  version,
-				Payload:  payload,
-				TypeCode: typecode,
-			},
-			Timestamp: eventTime,
-		}
+func (s *Store) feedExists(ctx context.Context, feedid string) (bool, error) {
+	var count int
+	err := s.queryRow(ctx, sqlFeedExists, feedid).Scan(&count)
+	return count > 0, err
+}
 
-		events = append(events, event)
-	}
+func (s *Store) retrieveEvents(ctx context.Context, query string, args ...interface{}) ([]TimestampedEvent, error) {
+	var events []TimestampedEvent
 
-	if err = rows.Err(); err != nil {
+	it, err := s.iterateEvents(ctx, query, args...)
+	if err != nil {
 		return events, err
 	}
 
-	return events, nil
+	err = it.Walk(func(event TimestampedEvent) error {
+		events = append(events, event)
+		return nil
+	})
+
+	return events, err
 }
 
 func RetrieveLastFeed(db *sql.DB) (string, error) {
-	var feedid string
+	return RetrieveLastFeedContext(context.Background(), db)
+}
+
+// RetrieveLastFeedContext is like RetrieveLastFeed, but the query is bound to ctx.
+func RetrieveLastFeedContext(ctx context.Context, db *sql.DB) (string, error) {
+	return newStore(db).RetrieveLastFeedContext(ctx)
+}
+
+// RetrieveLastFeedContext returns the newest archive feed, or an empty string if
+// no feeds have been created.
+func (s *Store) RetrieveLastFeedContext(ctx context.Context) (feedid string, err error) {
+	ctx, span := s.startSpan(ctx, "RetrieveLastFeed")
+	defer func() { endSpan(span, err) }()
 
-	err := db.QueryRow(sqlLatestFeedId).Scan(&feedid)
+	err = s.queryRow(ctx, sqlLatestFeedId).Scan(&feedid)
 	if err == sql.ErrNoRows {
 		return "", nil
 	} else if err != nil {
@@ -88,12 +123,55 @@ func RetrieveLastFeed(db *sql.DB) (string, error) {
 	return feedid, nil
 }
 
+// RetrieveFirstFeed returns the oldest archive feed, the start of the feed chain,
+// or an empty string if no feeds have been created. Readers can replay history from
+// this feed forwards using RetrieveNextFeed.
+func RetrieveFirstFeed(db *sql.DB) (string, error) {
+	return RetrieveFirstFeedContext(context.Background(), db)
+}
+
+// RetrieveFirstFeedContext is like RetrieveFirstFeed, but the query is bound to ctx.
+func RetrieveFirstFeedContext(ctx context.Context, db *sql.DB) (string, error) {
+	return newStore(db).RetrieveFirstFeedContext(ctx)
+}
+
+// RetrieveFirstFeedContext returns the oldest archive feed, or an empty string if
+// no feeds have been created.
+func (s *Store) RetrieveFirstFeedContext(ctx context.Context) (feedid string, err error) {
+	ctx, span := s.startSpan(ctx, "RetrieveFirstFeed")
+	defer func() { endSpan(span, err) }()
+
+	err = s.queryRow(ctx, sqlSelectFirstFeed).Scan(&feedid)
+	if err == sql.ErrNoRows {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	return feedid, nil
+}
+
+// RetrievePreviousFeed returns the feed preceding the given feed. The returned
+// feed id is not valid when id is the first feed in the chain, and ErrFeedNotFound
+// is returned when there is no feed with the given id.
 func RetrievePreviousFeed(db *sql.DB, id string) (sql.NullString, error) {
-	var feedid sql.NullString
+	return RetrievePreviousFeedContext(context.Background(), db, id)
+}
+
+// RetrievePreviousFeedContext is like RetrievePreviousFeed, but the query is bound to ctx.
+func RetrievePreviousFeedContext(ctx context.Context, db *sql.DB, id string) (sql.NullString, error) {
+	return newStore(db).RetrievePreviousFeedContext(ctx, id)
+}
+
+// RetrievePreviousFeedContext returns the feed preceding the given feed, as
+// described for RetrievePreviousFeed.
+func (s *Store) RetrievePreviousFeedContext(ctx context.Context, id string) (feedid sql.NullString, err error) {
+	ctx, span := s.startSpan(ctx, "RetrievePreviousFeed", AttributeFeedID.String(id))
+	defer func() { endSpan(span, err) }()
 
-	err := db.QueryRow(sqlSelectPreviousFeed, id).Scan(&feedid)
+	err = s.queryRow(ctx, sqlSelectPreviousFeed, id).Scan(&feedid)
 	if err == sql.ErrNoRows {
-		return feedid, nil
+		return feedid, ErrFeedNotFound
 	} else if err != nil {
 		return feedid, err
 	}
@@ -101,11 +179,36 @@ func RetrievePreviousFeed(db *sql.DB, id string) (sql.NullString, error) {
 	return feedid, nil
 }
 
+// RetrieveNextFeed returns the feed following the given feed. The returned feed
+// id is not valid when feedId is the most recent archive, and ErrFeedNotFound is
+// returned when there is no feed with the given id.
 func RetrieveNextFeed(db *sql.DB, feedId string) (sql.NullString, error) {
-	var previous sql.NullString
+	return RetrieveNextFeedContext(context.Background(), db, feedId)
+}
 
-	err := db.QueryRow(sqlSelectNextFeed, feedId).Scan(&previous)
+// RetrieveNextFeedContext is like RetrieveNextFeed, but the query is bound to ctx.
+func RetrieveNextFeedContext(ctx context.Context, db *sql.DB, feedId string) (sql.NullString, error) {
+	return newStore(db).RetrieveNextFeedContext(ctx, feedId)
+}
+
+// RetrieveNextFeedContext returns the feed following the given feed, as described
+// for RetrieveNextFeed.
+func (s *Store) RetrieveNextFeedContext(ctx context.Context, feedId string) (previous sql.NullString, err error) {
+	ctx, span := s.startSpan(ctx, "RetrieveNextFeed", AttributeFeedID.String(feedId))
+	defer func() { endSpan(span, err) }()
+
+	err = s.queryRow(ctx, sqlSelectNextFeed, feedId).Scan(&previous)
 	if err == sql.ErrNoRows {
+		//End of the chain, or no such feed?
+		exists, err := s.feedExists(ctx, feedId)
+		if err != nil {
+			return previous, err
+		}
+
+		if !exists {
+			return previous, ErrFeedNotFound
+		}
+
 		return previous, nil
 	} else if err != nil {
 		return previous, err
@@ -114,18 +217,41 @@ func RetrieveNextFeed(db *sql.DB, feedId string) (sql.NullString, error) {
 	return previous, nil
 }
 
+// RetrieveEvent returns the event with the given aggregate id and version, or
+// ErrEventNotFound if no such event has been stored.
 func RetrieveEvent(db *sql.DB, aggID string, version int) (TimestampedEvent, error) {
-	var event TimestampedEvent
+	return RetrieveEventContext(context.Background(), db, aggID, version)
+}
 
+// RetrieveEventContext is like RetrieveEvent, but the query is bound to ctx.
+func RetrieveEventContext(ctx context.Context, db *sql.DB, aggID string, version int) (TimestampedEvent, error) {
+	return newStore(db).RetrieveEventContext(ctx, aggID, version)
+}
+
+// RetrieveEventContext returns the event with the given aggregate id and version,
+// or ErrEventNotFound if no such event has been stored.
+func (s *Store) RetrieveEventContext(ctx context.Context, aggID string, version int) (event TimestampedEvent, err error) {
+	ctx, span := s.startSpan(ctx, "RetrieveEvent",
+		AttributeAggregateID.String(aggID),
+		AttributeVersion.Int(version),
+	)
+	defer func() { endSpan(span, err) }()
+
+	var sequence int64
+	var feedid sql.NullString
 	var eventTime time.Time
 	var typecode string
 	var payload []byte
 
-	err := db.QueryRow(sqlSelectEvent, aggID, version).Scan(&eventTime, &typecode, &payload)
-	if err != nil {
-		return event, err //Caller can sort out no rows vs other error
+	err = s.queryRow(ctx, sqlSelectEvent, aggID, version).Scan(&sequence, &feedid, &eventTime, &typecode, &payload)
+	if err == sql.ErrNoRows {
+		return event, ErrEventNotFound
+	} else if err != nil {
+		return event, err
 	}
 
+	span.SetAttributes(AttributeTypeCode.String(typecode), AttributeFeedID.String(feedid.String))
+
 	event = TimestampedEvent{
 		Event: goes.Event{
 			Source:   aggID,
@@ -134,6 +260,8 @@ func RetrieveEvent(db *sql.DB, aggID string, version int) (TimestampedEvent, err
 			TypeCode: typecode,
 		},
 		Timestamp: eventTime,
+		Sequence:  sequence,
+		FeedID:    feedid.String,
 	}
 
 	return event, nil
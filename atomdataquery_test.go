@@ -1,7 +1,7 @@
 package esatompub
 
 import (
-	"database/sql"
+	"context"
 	"errors"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/DATA-DOG/go-sqlmock.v1"
@@ -17,9 +17,9 @@ func TestQueryForRecent(t *testing.T) {
 	defer db.Close()
 
 	ts := time.Now()
-	rows := sqlmock.NewRows([]string{"event_time", "aggregate_id",
+	rows := sqlmock.NewRows([]string{"id", "feedid", "event_time", "aggregate_id",
 		"version", "typecode", "payload"},
-	).AddRow(ts, "1x2x333", 3, "foo", []byte("yeah ok"))
+	).AddRow(int64(1), nil, ts, "1x2x333", 3, "foo", []byte("yeah ok"))
 	mock.ExpectQuery("select").WillReturnRows(rows)
 
 	events, err := RetrieveRecent(db)
@@ -85,9 +85,9 @@ func TestQueryForRecentFinalRowsError(t *testing.T) {
 	defer db.Close()
 
 	ts := time.Now()
-	rows := sqlmock.NewRows([]string{"event_time", "aggregate_id",
+	rows := sqlmock.NewRows([]string{"id", "feedid", "event_time", "aggregate_id",
 		"version", "typecode", "payload"},
-	).AddRow(ts, "1x2x333", 3, "foo", []byte("yeah ok")).RowError(0, errors.New("dang"))
+	).AddRow(int64(2), nil, ts, "1x2x333", 3, "foo", []byte("yeah ok")).RowError(0, errors.New("dang"))
 	mock.ExpectQuery("select").WillReturnRows(rows)
 
 	_, err = RetrieveRecent(db)
@@ -153,6 +153,53 @@ func TestQueryForLastFeedError(t *testing.T) {
 	}
 }
 
+func TestQueryForFirstFeed(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	rows := sqlmock.NewRows([]string{"feedid"}).AddRow("feed-001")
+	mock.ExpectQuery(`select feedid from t_aefd_feed where id = \(select min\(id\)`).WillReturnRows(rows)
+
+	feedid, err := RetrieveFirstFeed(db)
+	if assert.Nil(t, err) {
+		assert.Nil(t, mock.ExpectationsWereMet())
+		assert.Equal(t, "feed-001", feedid)
+	}
+}
+
+func TestQueryForFirstFeedNoFeed(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	rows := sqlmock.NewRows([]string{"feedid"})
+	mock.ExpectQuery("select").WillReturnRows(rows)
+
+	feedid, err := RetrieveFirstFeed(db)
+	assert.Nil(t, err)
+	assert.Equal(t, "", feedid)
+}
+
+func TestQueryForFirstFeedError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("select").WillReturnError(errors.New("dang"))
+
+	_, err = RetrieveFirstFeed(db)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "dang", err.Error())
+	}
+}
+
 func TestQueryForArchive(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -161,9 +208,9 @@ func TestQueryForArchive(t *testing.T) {
 	defer db.Close()
 
 	ts := time.Now()
-	rows := sqlmock.NewRows([]string{"event_time", "aggregate_id",
+	rows := sqlmock.NewRows([]string{"id", "feedid", "event_time", "aggregate_id",
 		"version", "typecode", "payload"},
-	).AddRow(ts, "1x2x333", 3, "foo", []byte("yeah ok"))
+	).AddRow(int64(3), "foo", ts, "1x2x333", 3, "foo", []byte("yeah ok"))
 	mock.ExpectQuery("select").WithArgs("foo").WillReturnRows(rows)
 
 	events, err := RetrieveArchive(db, "foo")
@@ -177,10 +224,49 @@ func TestQueryForArchive(t *testing.T) {
 			assert.Equal(t, event.TypeCode, "foo")
 			assert.Equal(t, event.Source, "1x2x333")
 			assert.Equal(t, event.Version, 3)
+			assert.Equal(t, int64(3), event.Sequence)
+			assert.Equal(t, "foo", event.FeedID)
 		}
 	}
 }
 
+func TestQueryForArchiveNoFeed(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	rows := sqlmock.NewRows([]string{"id", "feedid", "event_time", "aggregate_id",
+		"version", "typecode", "payload"})
+	mock.ExpectQuery("select id, feedid").WithArgs("foo").WillReturnRows(rows)
+	countRows := sqlmock.NewRows([]string{"count(*)"}).AddRow(0)
+	mock.ExpectQuery("select count").WithArgs("foo").WillReturnRows(countRows)
+
+	_, err = RetrieveArchive(db, "foo")
+	assert.True(t, errors.Is(err, ErrFeedNotFound))
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestQueryForArchiveEmptyFeed(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	rows := sqlmock.NewRows([]string{"id", "feedid", "event_time", "aggregate_id",
+		"version", "typecode", "payload"})
+	mock.ExpectQuery("select id, feedid").WithArgs("foo").WillReturnRows(rows)
+	countRows := sqlmock.NewRows([]string{"count(*)"}).AddRow(1)
+	mock.ExpectQuery("select count").WithArgs("foo").WillReturnRows(countRows)
+
+	events, err := RetrieveArchive(db, "foo")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(events))
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
 func TestRetrievePreviousFeed(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -206,6 +292,20 @@ func TestRetrievePreviousFeedNoRows(t *testing.T) {
 	rows := sqlmock.NewRows([]string{"previous"})
 	mock.ExpectQuery("select").WithArgs("bar").WillReturnRows(rows)
 	previous, err := RetrievePreviousFeed(db, "bar")
+	assert.True(t, errors.Is(err, ErrFeedNotFound))
+	assert.False(t, previous.Valid)
+}
+
+func TestRetrievePreviousFeedFirstInChain(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	rows := sqlmock.NewRows([]string{"previous"}).AddRow(nil)
+	mock.ExpectQuery("select").WithArgs("bar").WillReturnRows(rows)
+	previous, err := RetrievePreviousFeed(db, "bar")
 	assert.Nil(t, err)
 	assert.False(t, previous.Valid)
 }
@@ -247,10 +347,29 @@ func TestRetrieveNextFeedNoRows(t *testing.T) {
 	defer db.Close()
 
 	rows := sqlmock.NewRows([]string{"feedid"})
-	mock.ExpectQuery("select").WithArgs("bar").WillReturnRows(rows)
+	mock.ExpectQuery("select feedid").WithArgs("bar").WillReturnRows(rows)
+	countRows := sqlmock.NewRows([]string{"count(*)"}).AddRow(1)
+	mock.ExpectQuery("select count").WithArgs("bar").WillReturnRows(countRows)
 	previous, err := RetrieveNextFeed(db, "bar")
 	assert.Nil(t, err)
 	assert.False(t, previous.Valid)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestRetrieveNextFeedNoFeed(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	rows := sqlmock.NewRows([]string{"feedid"})
+	mock.ExpectQuery("select feedid").WithArgs("bar").WillReturnRows(rows)
+	countRows := sqlmock.NewRows([]string{"count(*)"}).AddRow(0)
+	mock.ExpectQuery("select count").WithArgs("bar").WillReturnRows(countRows)
+	_, err = RetrieveNextFeed(db, "bar")
+	assert.True(t, errors.Is(err, ErrFeedNotFound))
+	assert.Nil(t, mock.ExpectationsWereMet())
 }
 
 func TestRetrieveNextFeedRowError(t *testing.T) {
@@ -275,9 +394,9 @@ func TestRetrieveEvent(t *testing.T) {
 	defer db.Close()
 
 	ts := time.Now()
-	rows := sqlmock.NewRows([]string{"event_time",
+	rows := sqlmock.NewRows([]string{"id", "feedid", "event_time",
 		"typecode", "payload"},
-	).AddRow(ts, "foo", []byte("yeah ok"))
+	).AddRow(int64(4), nil, ts, "foo", []byte("yeah ok"))
 	mock.ExpectQuery("select").WillReturnRows(rows)
 
 	event, err := RetrieveEvent(db, "1x2x333", 3)
@@ -289,6 +408,8 @@ func TestRetrieveEvent(t *testing.T) {
 			assert.Equal(t, event.TypeCode, "foo")
 			assert.Equal(t, event.Source, "1x2x333")
 			assert.Equal(t, event.Version, 3)
+			assert.Equal(t, int64(4), event.Sequence)
+			assert.Equal(t, "", event.FeedID)
 		}
 	}
 }
@@ -300,12 +421,46 @@ func TestRetrieveEventNoRow(t *testing.T) {
 	}
 	defer db.Close()
 
-	rows := sqlmock.NewRows([]string{"event_time",
+	rows := sqlmock.NewRows([]string{"id", "feedid", "event_time",
 		"typecode", "payload"})
 	mock.ExpectQuery("select").WillReturnRows(rows)
 
 	_, err = RetrieveEvent(db, "1x2x333", 3)
 	if assert.NotNil(t, err) {
-		assert.Equal(t, sql.ErrNoRows, err)
+		assert.True(t, errors.Is(err, ErrEventNotFound))
+	}
+}
+
+func TestQueryForRecentContextCancelled(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = RetrieveRecentContext(ctx, db)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestRetrieveEventContext(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	ts := time.Now()
+	rows := sqlmock.NewRows([]string{"id", "feedid", "event_time",
+		"typecode", "payload"},
+	).AddRow(int64(5), nil, ts, "foo", []byte("yeah ok"))
+	mock.ExpectQuery("select").WithArgs("1x2x333", 3).WillReturnRows(rows)
+
+	event, err := RetrieveEventContext(context.Background(), db, "1x2x333", 3)
+	if assert.Nil(t, err) {
+		assert.Nil(t, mock.ExpectationsWereMet())
+		assert.Equal(t, "foo", event.TypeCode)
 	}
 }
@@ -1,6 +1,7 @@
 package esatompub
 
 import (
+	"context"
 	"errors"
 	log "github.com/Sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
@@ -44,7 +45,7 @@ func TestReadPreviousFeedIdScanError(t *testing.T) {
 	mock.ExpectQuery(`select feedid from t_aefd_feed where id = \(select max\(id\) from t_aefd_feed\)`).WillReturnRows(rows)
 
 	tx, _ := db.Begin()
-	_, err = selectLatestFeed(tx)
+	_, err = newStore(db).selectLatestFeed(context.Background(), tx)
 	if assert.NotNil(t, err) {
 		err = mock.ExpectationsWereMet()
 		assert.Nil(t, err)
@@ -132,8 +133,8 @@ func testThresholdCountSetup(mock sqlmock.Sqlmock, ok *bool) {
 	}
 	if *ok == true {
 		rows := sqlmock.NewRows([]string{"feedid"}).AddRow("XXX")
-		rows = sqlmock.NewRows([]string{"count(*)"}).
-			AddRow(FeedThreshold)
+		rows = sqlmock.NewRows([]string{"count(*)", "max(id)"}).
+			AddRow(FeedThreshold, 42)
 		mock.ExpectQuery(`select count`).WillReturnRows(rows)
 	} else {
 		mock.ExpectQuery(`select count`).WillReturnError(errors.New("BAM!"))
@@ -249,3 +250,19 @@ func TestStatsdInit(t *testing.T) {
 	os.Unsetenv("STATSD_ENDPOINT") //Use inmem provider
 	NewESAtomPubProcessor()
 }
+
+func TestProcessEventContextCancelled(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	processor := NewESAtomPubProcessorContext(ctx)
+	err = processor.Processor(db, &goes.Event{Source: "agg1", Version: 1, TypeCode: "foo"})
+	assert.Equal(t, context.Canceled, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
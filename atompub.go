@@ -1,15 +1,17 @@
 package esatompub
 
 import (
+	"context"
 	"crypto/rand"
 	"database/sql"
 	"fmt"
-	log "github.com/Sirupsen/logrus"
 	"github.com/armon/go-metrics"
 	"github.com/xtracdev/goes"
 	"github.com/xtracdev/orapub"
+	"go.opentelemetry.io/otel/trace"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -18,7 +20,7 @@ const defaultFeedThreshold = 100
 const (
 	sqlLatestFeedId        = `select feedid from t_aefd_feed where id = (select max(id) from t_aefd_feed)`
 	sqlInsertEventIntoFeed = `insert into t_aeae_atom_event (aggregate_id, version,typecode, payload) values(:1,:2,:3,:4)`
-	sqlRecentFeedCount     = `select count(*) from t_aeae_atom_event where feedid is null`
+	sqlRecentFeedCount     = `select count(*), max(id) from t_aeae_atom_event where feedid is null`
 	sqlUpdateFeedIds       = `update t_aeae_atom_event set feedid = :1 where feedid is null`
 	sqlInsertFeed          = `insert into t_aefd_feed (feedid, previous) values (:1, :2)`
 	sqlLockTable           = `lock table t_aefd_feed in exclusive mode`
@@ -26,62 +28,124 @@ const (
 
 var FeedThreshold = defaultFeedThreshold
 
-func logDatabaseTimingStats(sql string, start time.Time, err error) {
+func (s *Store) logDatabaseTimingStats(sql string, start time.Time, err error) {
 	duration := time.Now().Sub(start)
+	if err != nil {
+		s.logger.Debug("Statement failed", LogFieldSQL, sql, "duration", duration, "error", err)
+	} else {
+		s.logger.Debug("Statement executed", LogFieldSQL, sql, "duration", duration)
+	}
 	go func(sql string, duration time.Duration, err error) {
 		ms := float32(duration.Nanoseconds()) / 1000.0 / 1000.0
 		if err != nil {
 			key := []string{"es-atom-data", "db", fmt.Sprintf("%s-error", sql)}
-			metrics.AddSample(key, float32(ms))
-			metrics.IncrCounter(key, 1)
+			s.metrics.AddSample(key, float32(ms))
+			s.metrics.IncrCounter(key, 1)
 		} else {
 			key := []string{"es-atom-data", "db", sql}
-			metrics.AddSample(key, float32(ms))
-			metrics.IncrCounter(key, 1)
+			s.metrics.AddSample(key, float32(ms))
+			s.metrics.IncrCounter(key, 1)
 		}
 	}(sql, duration, err)
 }
 
-func writeProcessEventStats(start time.Time, err error) {
+func (s *Store) writeProcessEventStats(start time.Time, err error) {
 	duration := time.Now().Sub(start)
 	go func(duration time.Duration, err error) {
 		ms := float32(duration.Nanoseconds()) / 1000.0 / 1000.0
 		if err != nil {
 			key := []string{"es-atom-data", "process-event", "error"}
-			metrics.AddSample(key, float32(ms))
-			metrics.IncrCounter(key, 1)
+			s.metrics.AddSample(key, float32(ms))
+			s.metrics.IncrCounter(key, 1)
 		} else {
 			key := []string{"es-atom-data", "process-event", "ok"}
-			metrics.AddSample(key, float32(ms))
-			metrics.IncrCounter(key, 1)
+			s.metrics.AddSample(key, float32(ms))
+			s.metrics.IncrCounter(key, 1)
 		}
 	}(duration, err)
 }
 
+// writeEventStats counts the event and samples its payload size in bytes, labelled
+// by typecode, feed name and outcome when the metrics support labels.
+func (s *Store) writeEventStats(event *goes.Event, err error) {
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+	}
+
+	labels := []Label{
+		{Name: "typecode", Value: s.typeCodes.label(event.TypeCode)},
+		{Name: "feed", Value: s.feedName},
+		{Name: "outcome", Value: outcome},
+	}
+	size, sized := payloadSize(event.Payload)
+
+	go func() {
+		lm, ok := s.metrics.(LabeledMetrics)
+		if !ok {
+			s.metrics.IncrCounter(eventsKey, 1)
+			if sized {
+				s.metrics.AddSample(payloadBytesKey, float32(size))
+			}
+			return
+		}
+
+		lm.IncrCounterWithLabels(eventsKey, 1, labels)
+		if sized {
+			lm.AddSampleWithLabels(payloadBytesKey, float32(size), labels)
+		}
+	}()
+}
+
+var (
+	eventsKey       = []string{"es-atom-data", "events"}
+	payloadBytesKey = []string{"es-atom-data", "payload", "bytes"}
+)
+
+func payloadSize(payload interface{}) (int, bool) {
+	switch p := payload.(type) {
+	case []byte:
+		return len(p), true
+	case string:
+		return len(p), true
+	default:
+		return 0, false
+	}
+}
+
+func (s *Store) writeRolloverStats(start time.Time) {
+	ms := durationMillis(start)
+	go func(ms float32) {
+		key := []string{"es-atom-data", "rollover"}
+		s.metrics.AddSample(key, ms)
+		s.metrics.IncrCounter(key, 1)
+	}(ms)
+}
+
 func ReadFeedThresholdFromEnv() {
 	thresholdOverride := os.Getenv("FEED_THRESHOLD")
 	if thresholdOverride != "" {
 		threshold, err := strconv.Atoi(thresholdOverride)
 		if err != nil {
-			log.Warnf("Attempted to override threshold with non integer: %s", thresholdOverride)
-			log.Warnf("Defaulting to %d", defaultFeedThreshold)
+			defaultLogger.Warn("Attempted to override feed threshold with non integer, using default",
+				"FEED_THRESHOLD", thresholdOverride, "default", defaultFeedThreshold)
 			FeedThreshold = defaultFeedThreshold
 			return
 		}
 
-		log.Infof("Overriding default feed threshold with %d", threshold)
+		defaultLogger.Info("Overriding default feed threshold", "threshold", threshold)
 		FeedThreshold = threshold
 	}
 }
 
-func selectLatestFeed(tx *sql.Tx) (sql.NullString, error) {
-	log.Debug("Select last feed id")
+func (s *Store) selectLatestFeed(ctx context.Context, tx *sql.Tx) (feedid sql.NullString, err error) {
+	ctx, span := s.startStatementSpan(ctx, "sqlLatestFeedId", sqlLatestFeedId)
+	defer func() { endSpan(span, err) }()
 
-	var feedid sql.NullString
 	start := time.Now()
-	rows, err := tx.Query(sqlLatestFeedId)
+	rows, err := s.queryTx(ctx, tx, sqlLatestFeedId)
 	if err != nil {
-		logDatabaseTimingStats("sqlLatestFeedId", start, err)
+		s.logDatabaseTimingStats("sqlLatestFeedId", start, err)
 		return feedid, err
 	}
 
@@ -89,7 +153,7 @@ func selectLatestFeed(tx *sql.Tx) (sql.NullString, error) {
 	for rows.Next() {
 		//Only one row can be returned at most
 		if err = rows.Scan(&feedid); err != nil {
-			logDatabaseTimingStats("sqlLatestFeedId", start, err)
+			s.logDatabaseTimingStats("sqlLatestFeedId", start, err)
 			return feedid, err
 		}
 	}
@@ -98,36 +162,44 @@ func selectLatestFeed(tx *sql.Tx) (sql.NullString, error) {
 		return feedid, err
 	}
 
-	logDatabaseTimingStats("sqlLatestFeedId", start, err)
+	s.logDatabaseTimingStats("sqlLatestFeedId", start, err)
 	return feedid, nil
 }
 
-func writeEventToAtomEventTable(tx *sql.Tx, event *goes.Event) error {
-	log.Debug("insert event into atom_event")
+func (s *Store) writeEventToAtomEventTable(ctx context.Context, tx *sql.Tx, event *goes.Event) error {
+	ctx, span := s.startStatementSpan(ctx, "sqlInsertEventIntoFeed", sqlInsertEventIntoFeed)
 	start := time.Now()
-	_, err := tx.Exec(sqlInsertEventIntoFeed,
+	_, err := s.execTx(ctx, tx, sqlInsertEventIntoFeed,
 		event.Source, event.Version, event.TypeCode, event.Payload)
-	logDatabaseTimingStats("sqlInsertEventIntoFeed", start, err)
+	s.logDatabaseTimingStats("sqlInsertEventIntoFeed", start, err)
+	endSpan(span, err)
 	return err
 }
 
-func getRecentFeedCount(tx *sql.Tx) (int, error) {
-	log.Debug("get current count")
+// getRecentFeedCount returns the number of recent events along with the largest
+// recent event id. As the table is locked that is the id of the event just written.
+func (s *Store) getRecentFeedCount(ctx context.Context, tx *sql.Tx) (int, int64, error) {
 	var count int
+	var lastId sql.NullInt64
+	ctx, span := s.startStatementSpan(ctx, "sqlRecentFeedCount", sqlRecentFeedCount)
 	start := time.Now()
-	err := tx.QueryRow(sqlRecentFeedCount).Scan(&count)
-	logDatabaseTimingStats("sqlRecentFeedCount", start, err)
+	err := s.queryRowTx(ctx, tx, sqlRecentFeedCount).Scan(&count, &lastId)
+	s.logDatabaseTimingStats("sqlRecentFeedCount", start, err)
+	endSpan(span, err)
 
-	return count, err
+	return count, lastId.Int64, err
 }
 
-func createNewFeed(tx *sql.Tx, currentFeedId sql.NullString) error {
-	log.Infof("Feed threshold of %d met", FeedThreshold)
+func (s *Store) createNewFeed(ctx context.Context, tx *sql.Tx, currentFeedId sql.NullString) (newFeedId string, err error) {
+	ctx, span := s.startSpan(ctx, "createNewFeed")
+	defer func() { endSpan(span, err) }()
+
 	var prevFeedId sql.NullString
 	uuidStr, err := uuid()
 	if err != nil {
-		return err
+		return "", err
 	}
+	span.SetAttributes(AttributeFeedID.String(uuidStr))
 
 	if currentFeedId.Valid {
 		prevFeedId = currentFeedId
@@ -135,112 +207,166 @@ func createNewFeed(tx *sql.Tx, currentFeedId sql.NullString) error {
 	}
 	currentFeedId = sql.NullString{String: uuidStr, Valid: true}
 
-	log.Info("Update feed ids")
-
+	updateCtx, updateSpan := s.startStatementSpan(ctx, "sqlUpdateFeedIds", sqlUpdateFeedIds)
 	start := time.Now()
-	_, err = tx.Exec(sqlUpdateFeedIds, currentFeedId)
-	logDatabaseTimingStats("sqlUpdateFeedIds", start, err)
+	_, err = s.execTx(updateCtx, tx, sqlUpdateFeedIds, currentFeedId)
+	s.logDatabaseTimingStats("sqlUpdateFeedIds", start, err)
+	endSpan(updateSpan, err)
 
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	log.Infof("Insert into feed %v, %v", currentFeedId, prevFeedId)
+	s.logger.Info("Creating feed", LogFieldFeedID, uuidStr, LogFieldPrevious, prevFeedId.String)
+	insertCtx, insertSpan := s.startStatementSpan(ctx, "sqlInsertFeed", sqlInsertFeed)
 	start = time.Now()
-	_, err = tx.Exec(sqlInsertFeed,
+	_, err = s.execTx(insertCtx, tx, sqlInsertFeed,
 		currentFeedId, prevFeedId)
-	logDatabaseTimingStats("sqlInsertFeed", start, err)
-	return err
+	s.logDatabaseTimingStats("sqlInsertFeed", start, err)
+	endSpan(insertSpan, err)
+	return uuidStr, err
 }
 
-func lockTable(tx *sql.Tx) error {
+func (s *Store) lockTable(ctx context.Context, tx *sql.Tx) error {
+	ctx, span := s.startStatementSpan(ctx, "sqlLockTable", sqlLockTable)
 	start := time.Now()
-	_, err := tx.Exec(sqlLockTable)
-	logDatabaseTimingStats("sqlLockTable", start, err)
+	_, err := s.execTx(ctx, tx, sqlLockTable)
+	s.logDatabaseTimingStats("sqlLockTable", start, err)
+	endSpan(span, err)
 	return err
 }
 
-func doRollback(tx *sql.Tx) {
+func (s *Store) doRollback(tx *sql.Tx) {
 	err := tx.Rollback()
 	if err != nil {
-		log.Warnf("Error on transaction rollback: %s", err.Error())
+		s.logger.Warn("Error on transaction rollback", "error", err)
+	}
+}
+
+// ProcessEventContext writes event to the recent page, archiving the page under a new
+// feed id once it holds the feed threshold of events, then publishes the changes to
+// the store's notifier.
+func (s *Store) ProcessEventContext(ctx context.Context, event *goes.Event) error {
+	ctx, span := s.startSpan(ctx, "processEvent",
+		AttributeAggregateID.String(event.Source),
+		AttributeVersion.Int(event.Version),
+		AttributeTypeCode.String(event.TypeCode),
+	)
+
+	start := time.Now()
+	err := s.processEvent(ctx, event)
+	s.writeProcessEventStats(start, err)
+	s.writeEventStats(event, err)
+	if err == nil {
+		s.writePublishLag(event)
 	}
+	endSpan(span, err)
+	return err
 }
 
-func processEvent(db *sql.DB, event *goes.Event) error {
-	log.Debug("Processor invoked")
+func (s *Store) processEvent(ctx context.Context, event *goes.Event) error {
+	s.logger.Debug("Processing event", LogFieldAggregateID, event.Source, LogFieldVersion, event.Version)
 
 	//Need a transaction to group the work in this method
-	log.Debug("create transaction")
-	tx, err := db.Begin()
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 
 	//Treat the processing as a critical section to avoid concurrency headaches.
-	err = lockTable(tx)
+	err = s.lockTable(ctx, tx)
 	if err != nil {
-		doRollback(tx)
+		s.doRollback(tx)
 		return err
 	}
 
 	//Get the current feed id
-	feedid, err := selectLatestFeed(tx)
+	feedid, err := s.selectLatestFeed(ctx, tx)
 	if err != nil {
-		doRollback(tx)
+		s.doRollback(tx)
 		return err
 	}
-	log.Debugf("previous feed id is %s", feedid.String)
+	s.logger.Debug("Selected latest feed", LogFieldFeedID, feedid.String)
 
 	//Insert current row
-	err = writeEventToAtomEventTable(tx, event)
+	err = s.writeEventToAtomEventTable(ctx, tx, event)
 	if err != nil {
-		doRollback(tx)
+		s.doRollback(tx)
 		return err
 	}
 
 	//Get current count of records in the current feed
-	count, err := getRecentFeedCount(tx)
+	count, sequence, err := s.getRecentFeedCount(ctx, tx)
 	if err != nil {
-		doRollback(tx)
+		s.doRollback(tx)
 		return err
 	}
-	log.Debugf("current count is %d", count)
+	s.logger.Debug("Counted recent events", "count", count)
 
 	//Threshold met
-	if count == FeedThreshold {
-		err := createNewFeed(tx, feedid)
+	var newFeedId string
+	if count == s.threshold() {
+		s.logger.Info("Feed threshold met", "threshold", s.threshold(),
+			LogFieldAggregateID, event.Source, LogFieldVersion, event.Version)
+		start := time.Now()
+		newFeedId, err = s.createNewFeed(ctx, tx, feedid)
 		if err != nil {
-			doRollback(tx)
+			s.doRollback(tx)
 			return err
 		}
+		s.writeRolloverStats(start)
+		trace.SpanFromContext(ctx).SetAttributes(AttributeFeedID.String(newFeedId))
 	}
 
-	log.Debug("commit txn")
 	err = tx.Commit()
 	if err != nil {
-		log.Warnf("Error commiting processEvent transaction: %s", err.Error())
+		s.logger.Warn("Error committing processEvent transaction",
+			LogFieldAggregateID, event.Source, LogFieldVersion, event.Version, "error", err)
 		return err
 	}
 
+	s.notifier.Publish(Notification{
+		Kind: EventStored,
+		Event: TimestampedEvent{
+			Event:     *event,
+			Timestamp: time.Now(),
+			Sequence:  sequence,
+			FeedID:    newFeedId,
+		},
+	})
+
+	if newFeedId != "" {
+		s.notifier.Publish(Notification{
+			Kind:     FeedCreated,
+			FeedID:   newFeedId,
+			Previous: feedid.String,
+		})
+	}
+
 	return nil
 }
 
-func configureStatsD() {
+var statsdOnce sync.Once
+
+// configureStatsD sets up the armon/go-metrics global from the environment. The
+// global is shared by every processor in the process, so it is only set up once.
+func configureStatsD(logger Logger) {
+	statsdOnce.Do(func() { configureStatsDFromEnv(logger) })
+}
+
+func configureStatsDFromEnv(logger Logger) {
 	statsdEndpoint := os.Getenv("STATSD_ENDPOINT")
-	log.Infof("STATSD_ENDPOINT: %s", statsdEndpoint)
 
 	if statsdEndpoint != "" {
-
-		log.Info("Using vanilla statsd client to send telemetry to ", statsdEndpoint)
+		logger.Info("Using vanilla statsd client to send telemetry", "STATSD_ENDPOINT", statsdEndpoint)
 		sink, err := metrics.NewStatsdSink(statsdEndpoint)
 		if err != nil {
-			log.Warn("Unable to configure statds sink", err.Error())
+			logger.Warn("Unable to configure statsd sink", "STATSD_ENDPOINT", statsdEndpoint, "error", err)
 			return
 		}
 		metrics.NewGlobal(metrics.DefaultConfig(statsdEndpoint), sink)
 	} else {
-		log.Info("Using in memory metrics accumulator - dump via USR1 signal")
+		logger.Info("Using in memory metrics accumulator - dump via USR1 signal")
 		inm := metrics.NewInmemSink(10*time.Second, 5*time.Minute)
 		metrics.DefaultInmemSignal(inm)
 		metrics.NewGlobal(metrics.DefaultConfig("xavi"), inm)
@@ -248,16 +374,63 @@ func configureStatsD() {
 }
 
 func NewESAtomPubProcessor() orapub.EventProcessor {
-	configureStatsD()
+	return NewESAtomPubProcessorContext(context.Background())
+}
+
+// NewESAtomPubProcessorContext returns a processor whose database work is bound to
+// ctx. Cancelling ctx, for example on shutdown, aborts in-flight event processing.
+func NewESAtomPubProcessorContext(ctx context.Context) orapub.EventProcessor {
+	return newESAtomPubProcessor(ctx, true)
+}
+
+// NewESAtomPubProcessorWithNotifier returns a processor that publishes the events it
+// stores, and the feeds it creates, to notifier.
+func NewESAtomPubProcessorWithNotifier(ctx context.Context, notifier *Notifier) orapub.EventProcessor {
+	return newESAtomPubProcessor(ctx, true, WithNotifier(notifier))
+}
+
+// NewESAtomPubProcessorWithMetrics returns a processor that emits its telemetry to m
+// instead of configuring the armon/go-metrics global from the environment.
+func NewESAtomPubProcessorWithMetrics(ctx context.Context, m Metrics) orapub.EventProcessor {
+	return newESAtomPubProcessor(ctx, false, WithMetrics(m))
+}
+
+// NewESAtomPubProcessorWithOptions returns a processor that stores events through a
+// Store configured with opts, for example WithTracerProvider. The armon/go-metrics
+// global is set up from the environment as it is for NewESAtomPubProcessor, and
+// receives the telemetry unless WithMetrics is given.
+func NewESAtomPubProcessorWithOptions(ctx context.Context, opts ...StoreOption) orapub.EventProcessor {
+	return newESAtomPubProcessor(ctx, true, opts...)
+}
+
+// newESAtomPubProcessor returns a processor storing events through a Store configured
+// with opts, setting up the armon/go-metrics global first if configureGlobal is set.
+func newESAtomPubProcessor(ctx context.Context, configureGlobal bool, opts ...StoreOption) orapub.EventProcessor {
+	if configureGlobal {
+		configureStatsD(defaultLogger)
+	}
+
 	return orapub.EventProcessor{
 		Initialize: func(db *sql.DB) error {
 			return nil
 		},
 		Processor: func(db *sql.DB, event *goes.Event) error {
-			start := time.Now()
-			err := processEvent(db, event)
-			writeProcessEventStats(start, err)
-			return err
+			return newStore(db, opts...).ProcessEventContext(ctx, event)
+		},
+	}
+}
+
+// NewWriterProcessor returns a processor that stores events through w rather than
+// the database handle passed to the processor, for example a Store with prepared
+// statements. Telemetry is emitted by the Writer.
+func NewWriterProcessor(ctx context.Context, w Writer) orapub.EventProcessor {
+	configureStatsD(defaultLogger)
+	return orapub.EventProcessor{
+		Initialize: func(db *sql.DB) error {
+			return nil
+		},
+		Processor: func(db *sql.DB, event *goes.Event) error {
+			return w.ProcessEventContext(ctx, event)
 		},
 	}
 }
@@ -0,0 +1,273 @@
+package esatompub
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/xtracdev/goes"
+	"go.opentelemetry.io/otel/attribute"
+	"time"
+)
+
+const defaultBackfillBatchSize = 500
+
+const (
+	sqlBackfillCheckpoint       = `select position from t_aebf_backfill where name = :1`
+	sqlBackfillCheckpointCount  = `select count(*) from t_aebf_backfill`
+	sqlUpdateBackfillCheckpoint = `update t_aebf_backfill set position = :1, event_time = current_timestamp where name = :2`
+	sqlInsertBackfillCheckpoint = `insert into t_aebf_backfill (name, position) values (:1, :2)`
+	sqlBackfillEvent            = `insert into t_aeae_atom_event (aggregate_id, version, typecode, payload, event_time) values (:1, :2, :3, :4, nvl(:5, current_timestamp))`
+)
+
+// SourceEvent is an event read from an EventSource.
+type SourceEvent struct {
+	goes.Event
+
+	// Position identifies the event's place in the source, such as an encoded key.
+	// Backfill checkpoints the position of the last event written and passes it back
+	// to ReadEvents to resume, so it must be stable and no longer than 400 bytes.
+	Position string
+
+	// Timestamp is when the event was stored in the source. It is recorded as the
+	// event time, or if zero the time of the backfill is recorded.
+	Timestamp time.Time
+}
+
+// EventSource supplies the events Backfill replays, such as the events in a goes
+// event store.
+type EventSource interface {
+	// ReadEvents returns up to limit events following the event at position after,
+	// in source order, or from the start of the source if after is empty. An empty
+	// result means there are no more events.
+	ReadEvents(ctx context.Context, after string, limit int) ([]SourceEvent, error)
+}
+
+// BackfillOptions configures Backfill.
+type BackfillOptions struct {
+	// Name identifies the backfill's checkpoint, so separate sources can be replayed
+	// in turn, each under its own name. Defaults to "default".
+	Name string
+
+	// BatchSize is the number of events read and written in each transaction.
+	// Defaults to 500.
+	BatchSize int
+
+	// Progress, if set, is called after each batch is committed.
+	Progress func(BackfillProgress)
+}
+
+// BackfillProgress reports the work done by Backfill.
+type BackfillProgress struct {
+	// Position is the position of the last event written, which is checkpointed.
+	Position string
+
+	// Events and Feeds count the events written and the feeds created so far by
+	// this call to Backfill.
+	Events int
+	Feeds  int
+}
+
+// Backfill replays the events from source into the atom data tables.
+func Backfill(db *sql.DB, source EventSource, opts BackfillOptions) (BackfillProgress, error) {
+	return BackfillContext(context.Background(), db, source, opts)
+}
+
+// BackfillContext is like Backfill, but the work is bound to ctx.
+func BackfillContext(ctx context.Context, db *sql.DB, source EventSource, opts BackfillOptions) (BackfillProgress, error) {
+	return newStore(db).BackfillContext(ctx, source, opts)
+}
+
+// BackfillContext replays the events from source into the atom data tables, archiving
+// the recent page each time it reaches the feed threshold, just as the processor
+// does. Events are written in batches, each in a transaction under the feed table
+// lock that also records the position of the last event in the t_aebf_backfill
+// checkpoint table. An interrupted backfill resumes from its checkpoint when run
+// again, and once caught up can be run again to pick up newer events.
+//
+// The first backfill, when no checkpoint of any name exists, must start with empty
+// atom data tables, or ErrBackfillTargetNotEmpty is returned; to rebuild the tables,
+// truncate them and the checkpoints first. A backfill under a new name may then
+// start against the tables filled by the earlier backfills, appending its events.
+// The processor should not write to the tables while the backfill runs. FeedCreated
+// is published to the store's notifier for each feed created, but EventStored is
+// not published.
+func (s *Store) BackfillContext(ctx context.Context, source EventSource, opts BackfillOptions) (progress BackfillProgress, err error) {
+	if opts.Name == "" {
+		opts.Name = "default"
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultBackfillBatchSize
+	}
+
+	ctx, span := s.startSpan(ctx, "Backfill", attribute.String("es.backfill", opts.Name))
+	defer func() { endSpan(span, err) }()
+
+	position, found, err := s.backfillCheckpoint(ctx, opts.Name)
+	if err != nil {
+		return progress, err
+	}
+	progress.Position = position
+
+	if found {
+		s.logger.Info("Resuming backfill", "backfill", opts.Name, "position", position)
+	} else if err = s.checkBackfillTarget(ctx); err != nil {
+		return progress, err
+	}
+
+	for {
+		events, err := source.ReadEvents(ctx, progress.Position, opts.BatchSize)
+		if err != nil {
+			return progress, err
+		}
+		if len(events) == 0 {
+			break
+		}
+
+		feeds, err := s.backfillBatch(ctx, opts.Name, progress.Position, events, found)
+		if err != nil {
+			return progress, err
+		}
+		found = true
+
+		progress.Position = events[len(events)-1].Position
+		progress.Events += len(events)
+		progress.Feeds += feeds
+
+		s.logger.Info("Backfill progress", "backfill", opts.Name,
+			"events", progress.Events, "feeds", progress.Feeds, "position", progress.Position)
+		if opts.Progress != nil {
+			opts.Progress(progress)
+		}
+	}
+
+	return progress, nil
+}
+
+func (s *Store) backfillCheckpoint(ctx context.Context, name string) (string, bool, error) {
+	var position string
+	err := s.queryRowOn(ctx, s.db, s.stmts, sqlBackfillCheckpoint, name).Scan(&position)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	return position, err == nil, err
+}
+
+// checkBackfillTarget allows a backfill without a checkpoint to start if earlier
+// backfills have written the tables, or if the tables are empty.
+func (s *Store) checkBackfillTarget(ctx context.Context) error {
+	var checkpoints int
+	if err := s.queryRowOn(ctx, s.db, s.stmts, sqlBackfillCheckpointCount).Scan(&checkpoints); err != nil {
+		return err
+	}
+	if checkpoints > 0 {
+		return nil
+	}
+
+	var feeds, events int
+	if err := s.queryRowOn(ctx, s.db, s.stmts, sqlImportTableCounts).Scan(&feeds, &events); err != nil {
+		return err
+	}
+	if feeds != 0 || events != 0 {
+		return ErrBackfillTargetNotEmpty
+	}
+	return nil
+}
+
+// backfillBatch writes events after position in a single transaction, returning the
+// number of feeds created.
+func (s *Store) backfillBatch(ctx context.Context, name string, position string, events []SourceEvent, checkpointed bool) (int, error) {
+	var created []Notification
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = s.lockTable(ctx, tx); err != nil {
+		s.doRollback(tx)
+		return 0, err
+	}
+
+	feedid, err := s.selectLatestFeed(ctx, tx)
+	if err != nil {
+		s.doRollback(tx)
+		return 0, err
+	}
+
+	count, _, err := s.getRecentFeedCount(ctx, tx)
+	if err != nil {
+		s.doRollback(tx)
+		return 0, err
+	}
+
+	for i := range events {
+		event := &events[i]
+		if event.Position == "" || event.Position == position {
+			s.doRollback(tx)
+			return 0, fmt.Errorf("event source returned position %q after position %q", event.Position, position)
+		}
+		position = event.Position
+
+		if err = s.writeBackfillEvent(ctx, tx, event); err != nil {
+			s.doRollback(tx)
+			return 0, err
+		}
+
+		count++
+		if count != s.threshold() {
+			continue
+		}
+
+		start := time.Now()
+		newFeedId, err := s.createNewFeed(ctx, tx, feedid)
+		if err != nil {
+			s.doRollback(tx)
+			return 0, err
+		}
+		s.writeRolloverStats(start)
+
+		created = append(created, Notification{Kind: FeedCreated, FeedID: newFeedId, Previous: feedid.String})
+		feedid = sql.NullString{String: newFeedId, Valid: true}
+		count = 0
+	}
+
+	if err = s.saveBackfillCheckpoint(ctx, tx, name, position, checkpointed); err != nil {
+		s.doRollback(tx)
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	for _, notification := range created {
+		s.notifier.Publish(notification)
+	}
+
+	return len(created), nil
+}
+
+func (s *Store) writeBackfillEvent(ctx context.Context, tx *sql.Tx, event *SourceEvent) error {
+	var timestamp sql.NullTime
+	if !event.Timestamp.IsZero() {
+		timestamp = sql.NullTime{Time: event.Timestamp, Valid: true}
+	}
+
+	ctx, span := s.startStatementSpan(ctx, "sqlBackfillEvent", sqlBackfillEvent)
+	start := time.Now()
+	_, err := s.execTx(ctx, tx, sqlBackfillEvent,
+		event.Source, event.Version, event.TypeCode, event.Payload, timestamp)
+	s.logDatabaseTimingStats("sqlBackfillEvent", start, err)
+	endSpan(span, err)
+	return err
+}
+
+func (s *Store) saveBackfillCheckpoint(ctx context.Context, tx *sql.Tx, name string, position string, checkpointed bool) error {
+	if checkpointed {
+		_, err := s.execTx(ctx, tx, sqlUpdateBackfillCheckpoint, position, name)
+		return err
+	}
+
+	_, err := s.execTx(ctx, tx, sqlInsertBackfillCheckpoint, name, position)
+	return err
+}
@@ -0,0 +1,166 @@
+package esatompub
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/goes"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"strconv"
+	"testing"
+)
+
+type sliceEventSource []SourceEvent
+
+func (events sliceEventSource) ReadEvents(ctx context.Context, after string, limit int) ([]SourceEvent, error) {
+	start := 0
+	for i, event := range events {
+		if event.Position == after {
+			start = i + 1
+		}
+	}
+
+	var batch []SourceEvent
+	for _, event := range events[start:] {
+		if len(batch) < limit {
+			batch = append(batch, event)
+		}
+	}
+	return batch, nil
+}
+
+func sourceEvents(n int) sliceEventSource {
+	var events sliceEventSource
+	for i := 1; i <= n; i++ {
+		events = append(events, SourceEvent{
+			Event:    goes.Event{Source: "agg1", Version: i, TypeCode: "foo", Payload: []byte("ok")},
+			Position: strconv.Itoa(i * 10),
+		})
+	}
+	return events
+}
+
+func expectBackfillBatchStart(mock sqlmock.Sqlmock, feedid interface{}, recent int) {
+	mock.ExpectBegin()
+	mock.ExpectExec("lock table t_aefd_feed").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("select feedid from t_aefd_feed").WillReturnRows(sqlmock.NewRows([]string{"feedid"}).AddRow(feedid))
+	mock.ExpectQuery("select count").WillReturnRows(sqlmock.NewRows([]string{"count(*)", "max(id)"}).AddRow(recent, nil))
+}
+
+func TestBackfill(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	inserted := sqlmock.NewResult(1, 1)
+
+	mock.ExpectQuery("select position from t_aebf_backfill").WithArgs("default").WillReturnRows(sqlmock.NewRows([]string{"position"}))
+	mock.ExpectQuery("select count\\(\\*\\) from t_aebf_backfill").WillReturnRows(sqlmock.NewRows([]string{"count(*)"}).AddRow(0))
+	mock.ExpectQuery("from dual").WillReturnRows(sqlmock.NewRows([]string{"feeds", "events"}).AddRow(0, 0))
+
+	expectBackfillBatchStart(mock, nil, 0)
+	mock.ExpectExec("insert into t_aeae_atom_event").WithArgs("agg1", 1, "foo", []byte("ok"), nil).WillReturnResult(inserted)
+	mock.ExpectExec("insert into t_aeae_atom_event").WithArgs("agg1", 2, "foo", []byte("ok"), nil).WillReturnResult(inserted)
+	mock.ExpectExec("update t_aeae_atom_event set feedid").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("insert into t_aefd_feed").WithArgs(sqlmock.AnyArg(), nil).WillReturnResult(inserted)
+	mock.ExpectExec("insert into t_aebf_backfill").WithArgs("default", "20").WillReturnResult(inserted)
+	mock.ExpectCommit()
+
+	expectBackfillBatchStart(mock, "f1", 0)
+	mock.ExpectExec("insert into t_aeae_atom_event").WithArgs("agg1", 3, "foo", []byte("ok"), nil).WillReturnResult(inserted)
+	mock.ExpectExec("update t_aebf_backfill").WithArgs("30", "default").WillReturnResult(inserted)
+	mock.ExpectCommit()
+
+	var reported []BackfillProgress
+	opts := BackfillOptions{
+		BatchSize: 2,
+		Progress:  func(p BackfillProgress) { reported = append(reported, p) },
+	}
+
+	progress, err := newStore(db, WithFeedThreshold(2)).BackfillContext(context.Background(), sourceEvents(3), opts)
+	if assert.Nil(t, err) {
+		assert.Equal(t, BackfillProgress{Position: "30", Events: 3, Feeds: 1}, progress)
+		assert.Equal(t, []BackfillProgress{{Position: "20", Events: 2, Feeds: 1}, progress}, reported)
+	}
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestBackfillResumes(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("select position from t_aebf_backfill").WithArgs("orders").WillReturnRows(sqlmock.NewRows([]string{"position"}).AddRow("20"))
+	expectBackfillBatchStart(mock, "f1", 1)
+	mock.ExpectExec("insert into t_aeae_atom_event").WithArgs("agg1", 3, "foo", []byte("ok"), nil).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("update t_aebf_backfill").WithArgs("30", "orders").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	progress, err := Backfill(db, sourceEvents(3), BackfillOptions{Name: "orders"})
+	if assert.Nil(t, err) {
+		assert.Equal(t, BackfillProgress{Position: "30", Events: 1}, progress)
+	}
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestBackfillTargetNotEmpty(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("select position from t_aebf_backfill").WillReturnRows(sqlmock.NewRows([]string{"position"}))
+	mock.ExpectQuery("select count\\(\\*\\) from t_aebf_backfill").WillReturnRows(sqlmock.NewRows([]string{"count(*)"}).AddRow(0))
+	mock.ExpectQuery("from dual").WillReturnRows(sqlmock.NewRows([]string{"feeds", "events"}).AddRow(0, 3))
+
+	_, err = Backfill(db, sourceEvents(3), BackfillOptions{})
+	assert.Equal(t, ErrBackfillTargetNotEmpty, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestBackfillRejectsOutOfOrderEvents(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	events := sourceEvents(2)
+	events[1].Position = events[0].Position
+
+	mock.ExpectQuery("select position from t_aebf_backfill").WillReturnRows(sqlmock.NewRows([]string{"position"}).AddRow(""))
+	expectBackfillBatchStart(mock, nil, 0)
+	mock.ExpectExec("insert into t_aeae_atom_event").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectRollback()
+
+	_, err = Backfill(db, events, BackfillOptions{})
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), `position "10" after position "10"`)
+	}
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestBackfillNewNameAfterEarlierBackfill(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("select position from t_aebf_backfill").WithArgs("invoices").WillReturnRows(sqlmock.NewRows([]string{"position"}))
+	mock.ExpectQuery("select count\\(\\*\\) from t_aebf_backfill").WillReturnRows(sqlmock.NewRows([]string{"count(*)"}).AddRow(1))
+	expectBackfillBatchStart(mock, "f1", 1)
+	mock.ExpectExec("insert into t_aeae_atom_event").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("insert into t_aebf_backfill").WithArgs("invoices", "10").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	progress, err := Backfill(db, sourceEvents(1), BackfillOptions{Name: "invoices"})
+	if assert.Nil(t, err) {
+		assert.Equal(t, BackfillProgress{Position: "10", Events: 1}, progress)
+	}
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,203 @@
+package esatompub
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"sync"
+)
+
+const (
+	cachedArchive = iota
+	cachedPrevious
+	cachedNext
+)
+
+var cacheKindNames = []string{"archive", "previous", "next"}
+
+type cacheKey struct {
+	kind   int
+	feedid string
+}
+
+type cacheEntry struct {
+	key    cacheKey
+	events []TimestampedEvent
+	feedid sql.NullString
+}
+
+// ArchiveCache is a size bounded, least recently used cache in front of the archive
+// queries. An archive's events and its previous link never change once the feed is
+// created, so they are cached on first read. A missing next link is never cached,
+// as the newest archive gains a next link when the following feed is created; this
+// keeps the cache correct however many processes write to the feed.
+//
+// ArchiveCache implements Reader; queries other than the archive lookups pass
+// straight through to the wrapped Reader.
+type ArchiveCache struct {
+	Reader
+
+	metrics Metrics
+	size    int
+	mu      sync.Mutex
+	entries *list.List
+	index   map[cacheKey]*list.Element
+}
+
+// NewArchiveCache returns a cache holding up to size entries, reading through to db.
+// A size less than one disables caching.
+func NewArchiveCache(db *sql.DB, size int) *ArchiveCache {
+	return NewCachingReader(newStore(db), size)
+}
+
+// NewCachingReader returns a cache holding up to size entries, reading through to reader.
+// A size less than one disables caching.
+func NewCachingReader(reader Reader, size int) *ArchiveCache {
+	return NewCachingReaderWithMetrics(reader, size, defaultMetrics)
+}
+
+// NewCachingReaderWithMetrics is like NewCachingReader, but cache hits and misses are
+// counted in m.
+func NewCachingReaderWithMetrics(reader Reader, size int, m Metrics) *ArchiveCache {
+	return &ArchiveCache{
+		Reader:  reader,
+		metrics: m,
+		size:    size,
+		entries: list.New(),
+		index:   make(map[cacheKey]*list.Element),
+	}
+}
+
+func (c *ArchiveCache) get(key cacheKey) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.index[key]
+	if ok {
+		c.entries.MoveToFront(elem)
+	}
+
+	go c.metrics.IncrCounter([]string{"es-atom-data", "cache", cacheKindNames[key.kind], hitOrMiss(ok)}, 1)
+
+	if !ok {
+		return nil, false
+	}
+	return elem.Value.(*cacheEntry), true
+}
+
+func hitOrMiss(hit bool) string {
+	if hit {
+		return "hit"
+	}
+	return "miss"
+}
+
+func (c *ArchiveCache) put(entry *cacheEntry) {
+	if c.size < 1 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.index[entry.key]; ok {
+		elem.Value = entry
+		c.entries.MoveToFront(elem)
+		return
+	}
+
+	c.index[entry.key] = c.entries.PushFront(entry)
+
+	for c.entries.Len() > c.size {
+		oldest := c.entries.Back()
+		c.entries.Remove(oldest)
+		delete(c.index, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// Len returns the number of cached entries.
+func (c *ArchiveCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries.Len()
+}
+
+// Purge discards every cached entry. Repair does this for the caches it is given,
+// as repairing the feed chain can change archive pages and their links.
+func (c *ArchiveCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries.Init()
+	c.index = make(map[cacheKey]*list.Element)
+}
+
+// RetrieveArchive is like the package level RetrieveArchive, but served from the
+// cache when possible.
+func (c *ArchiveCache) RetrieveArchive(feedid string) ([]TimestampedEvent, error) {
+	return c.RetrieveArchiveContext(context.Background(), feedid)
+}
+
+// RetrieveArchiveContext is like RetrieveArchive, but the query is bound to ctx.
+func (c *ArchiveCache) RetrieveArchiveContext(ctx context.Context, feedid string) ([]TimestampedEvent, error) {
+	key := cacheKey{kind: cachedArchive, feedid: feedid}
+	if entry, ok := c.get(key); ok {
+		return append([]TimestampedEvent(nil), entry.events...), nil
+	}
+
+	events, err := c.Reader.RetrieveArchiveContext(ctx, feedid)
+	if err != nil {
+		return events, err
+	}
+
+	c.put(&cacheEntry{key: key, events: events})
+	return append([]TimestampedEvent(nil), events...), nil
+}
+
+// RetrievePreviousFeed is like the package level RetrievePreviousFeed, but served
+// from the cache when possible.
+func (c *ArchiveCache) RetrievePreviousFeed(id string) (sql.NullString, error) {
+	return c.RetrievePreviousFeedContext(context.Background(), id)
+}
+
+// RetrievePreviousFeedContext is like RetrievePreviousFeed, but the query is bound to ctx.
+func (c *ArchiveCache) RetrievePreviousFeedContext(ctx context.Context, id string) (sql.NullString, error) {
+	key := cacheKey{kind: cachedPrevious, feedid: id}
+	if entry, ok := c.get(key); ok {
+		return entry.feedid, nil
+	}
+
+	previous, err := c.Reader.RetrievePreviousFeedContext(ctx, id)
+	if err != nil {
+		return previous, err
+	}
+
+	c.put(&cacheEntry{key: key, feedid: previous})
+	return previous, nil
+}
+
+// RetrieveNextFeed is like the package level RetrieveNextFeed, but served from the
+// cache when possible.
+func (c *ArchiveCache) RetrieveNextFeed(feedId string) (sql.NullString, error) {
+	return c.RetrieveNextFeedContext(context.Background(), feedId)
+}
+
+// RetrieveNextFeedContext is like RetrieveNextFeed, but the query is bound to ctx.
+func (c *ArchiveCache) RetrieveNextFeedContext(ctx context.Context, feedId string) (sql.NullString, error) {
+	key := cacheKey{kind: cachedNext, feedid: feedId}
+	if entry, ok := c.get(key); ok {
+		return entry.feedid, nil
+	}
+
+	next, err := c.Reader.RetrieveNextFeedContext(ctx, feedId)
+	if err != nil {
+		return next, err
+	}
+
+	//The newest archive will get a next feed when the recent page rolls over
+	if next.Valid {
+		c.put(&cacheEntry{key: key, feedid: next})
+	}
+
+	return next, nil
+}
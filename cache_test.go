@@ -0,0 +1,167 @@
+package esatompub
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"testing"
+	"time"
+)
+
+func archiveRows(feedid string) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "feedid", "event_time", "aggregate_id",
+		"version", "typecode", "payload"},
+	).AddRow(int64(1), feedid, time.Now(), "agg1", 1, "foo", []byte("ok"))
+}
+
+func TestArchiveCacheReadsThroughOnce(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("select").WithArgs("feed-1").WillReturnRows(archiveRows("feed-1"))
+
+	cache := NewArchiveCache(db, 10)
+	for i := 0; i < 3; i++ {
+		events, err := cache.RetrieveArchive("feed-1")
+		if assert.Nil(t, err) && assert.Equal(t, 1, len(events)) {
+			assert.Equal(t, "feed-1", events[0].FeedID)
+		}
+	}
+
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestArchiveCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("select").WithArgs("feed-1").WillReturnRows(archiveRows("feed-1"))
+	mock.ExpectQuery("select").WithArgs("feed-2").WillReturnRows(archiveRows("feed-2"))
+	mock.ExpectQuery("select").WithArgs("feed-3").WillReturnRows(archiveRows("feed-3"))
+	mock.ExpectQuery("select").WithArgs("feed-2").WillReturnRows(archiveRows("feed-2"))
+
+	cache := NewArchiveCache(db, 2)
+	cache.RetrieveArchive("feed-1")
+	cache.RetrieveArchive("feed-2")
+	cache.RetrieveArchive("feed-1") //feed-2 is now least recently used
+	cache.RetrieveArchive("feed-3")
+	cache.RetrieveArchive("feed-1")
+	cache.RetrieveArchive("feed-2")
+
+	assert.Equal(t, 2, cache.Len())
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestArchiveCachePurge(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("select").WithArgs("feed-1").WillReturnRows(archiveRows("feed-1"))
+	mock.ExpectQuery("select").WithArgs("feed-1").WillReturnRows(archiveRows("feed-1"))
+
+	cache := NewArchiveCache(db, 10)
+	cache.RetrieveArchive("feed-1")
+	assert.Equal(t, 1, cache.Len())
+
+	cache.Purge()
+	assert.Equal(t, 0, cache.Len())
+
+	_, err = cache.RetrieveArchive("feed-1")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, cache.Len())
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestArchiveCacheDisabled(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("select").WithArgs("feed-1").WillReturnRows(archiveRows("feed-1"))
+	mock.ExpectQuery("select").WithArgs("feed-1").WillReturnRows(archiveRows("feed-1"))
+
+	for _, size := range []int{0, -1} {
+		cache := NewArchiveCache(db, size)
+		events, err := cache.RetrieveArchive("feed-1")
+		if assert.Nil(t, err) {
+			assert.Equal(t, 1, len(events))
+		}
+		assert.Equal(t, 0, cache.Len())
+	}
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestArchiveCacheDoesNotCacheErrors(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("select").WithArgs("feed-1").WillReturnError(errors.New("boom"))
+	mock.ExpectQuery("select").WithArgs("feed-1").WillReturnRows(archiveRows("feed-1"))
+
+	cache := NewArchiveCache(db, 10)
+	_, err = cache.RetrieveArchive("feed-1")
+	assert.NotNil(t, err)
+	_, err = cache.RetrieveArchive("feed-1")
+	assert.Nil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestArchiveCachePreviousFeed(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("select previous").WithArgs("feed-1").
+		WillReturnRows(sqlmock.NewRows([]string{"previous"}).AddRow(nil))
+
+	cache := NewArchiveCache(db, 10)
+	for i := 0; i < 2; i++ {
+		previous, err := cache.RetrievePreviousFeed("feed-1")
+		assert.Nil(t, err)
+		assert.False(t, previous.Valid)
+	}
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestArchiveCacheNextOfNewestArchiveNotCached(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	//feed-2 is the newest archive...
+	mock.ExpectQuery("select feedid").WithArgs("feed-2").WillReturnRows(sqlmock.NewRows([]string{"feedid"}))
+	mock.ExpectQuery("select count").WithArgs("feed-2").WillReturnRows(sqlmock.NewRows([]string{"count(*)"}).AddRow(1))
+
+	//...until the recent page rolls over into feed-3
+	mock.ExpectQuery("select feedid").WithArgs("feed-2").WillReturnRows(sqlmock.NewRows([]string{"feedid"}).AddRow("feed-3"))
+
+	cache := NewArchiveCache(db, 10)
+	next, err := cache.RetrieveNextFeed("feed-2")
+	assert.Nil(t, err)
+	assert.False(t, next.Valid)
+
+	for i := 0; i < 2; i++ {
+		next, err = cache.RetrieveNextFeed("feed-2")
+		assert.Nil(t, err)
+		assert.Equal(t, "feed-3", next.String)
+	}
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
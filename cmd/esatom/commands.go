@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/xtracdev/es-atom-data"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// errUsage is returned by a command given the wrong arguments.
+var errUsage = errors.New("usage")
+
+// errAnomalies is returned by verify when the feed chain has anomalies.
+var errAnomalies = errors.New("feed chain has anomalies")
+
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(ctx context.Context, db *sql.DB, flags *flag.FlagSet, out io.Writer) error
+	flags   func(flags *flag.FlagSet)
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{name: "feeds", args: "[-offset n] [-limit n]", summary: "list the archive feeds, oldest first", run: runFeeds, flags: feedsFlags},
+		{name: "feed", args: "[-payload] <feedid|recent>", summary: "show the events in an archive feed or the recent page", run: runFeed, flags: payloadFlag},
+		{name: "event", args: "[-payload] <aggregate-id> <version>", summary: "show an event by aggregate id and version", run: runEvent, flags: payloadFlag},
+		{name: "stats", summary: "print feed chain statistics", run: runStats},
+		{name: "verify", args: "[-repair] [-repage]", summary: "verify the feed chain, optionally repairing it", run: runVerify, flags: verifyFlags},
+		{name: "rollover", summary: "archive the recent page under a new feed now", run: runRollover},
+		{name: "export", args: "[-o file]", summary: "export the feed history as NDJSON", run: runExport, flags: exportFlags},
+		{name: "import", args: "<file|->", summary: "import an export into empty atom data tables", run: runImport},
+	}
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// runCommand parses the command's arguments and runs it, returning the exit status.
+func runCommand(ctx context.Context, cmd *command, db *sql.DB, args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "usage: esatom %s %s\n", cmd.name, cmd.args)
+		flags.PrintDefaults()
+	}
+	if cmd.flags != nil {
+		cmd.flags(flags)
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	err := cmd.run(ctx, db, flags, stdout)
+	switch {
+	case err == nil:
+		return 0
+	case err == errUsage:
+		flags.Usage()
+		return 2
+	default:
+		fmt.Fprintf(stderr, "esatom %s: %s\n", cmd.name, err)
+		return 1
+	}
+}
+
+func feedsFlags(flags *flag.FlagSet) {
+	flags.Int("offset", 0, "number of feeds to skip")
+	flags.Int("limit", 0, "maximum number of feeds to list, 0 for all")
+}
+
+func payloadFlag(flags *flag.FlagSet) {
+	flags.Bool("payload", false, "print event payloads")
+}
+
+func verifyFlags(flags *flag.FlagSet) {
+	flags.Bool("repair", false, "rebuild the feed chain if anomalies are found")
+	flags.Bool("repage", false, "with -repair, reassign events to pages of the feed threshold")
+}
+
+func exportFlags(flags *flag.FlagSet) {
+	flags.String("o", "-", "file to write the export to, - for standard out")
+}
+
+func intFlag(flags *flag.FlagSet, name string) int {
+	return flags.Lookup(name).Value.(flag.Getter).Get().(int)
+}
+
+func boolFlag(flags *flag.FlagSet, name string) bool {
+	return flags.Lookup(name).Value.(flag.Getter).Get().(bool)
+}
+
+func stringFlag(flags *flag.FlagSet, name string) string {
+	return flags.Lookup(name).Value.String()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+func formatNullString(s sql.NullString) string {
+	if !s.Valid {
+		return "-"
+	}
+	return s.String
+}
+
+func runFeeds(ctx context.Context, db *sql.DB, flags *flag.FlagSet, out io.Writer) error {
+	if flags.NArg() != 0 {
+		return errUsage
+	}
+
+	opts := []esatompub.QueryOption{esatompub.WithOffset(intFlag(flags, "offset"))}
+	if limit := intFlag(flags, "limit"); limit > 0 {
+		opts = append(opts, esatompub.WithLimit(limit))
+	}
+
+	feeds, err := esatompub.ListFeedsContext(ctx, db, opts...)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "FEED\tCREATED\tENTRIES\tFIRST EVENT\tLAST EVENT\tPREVIOUS\tNEXT")
+	for _, feed := range feeds {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\t%s\n",
+			feed.FeedID, formatTime(feed.Created), feed.EntryCount,
+			formatTime(feed.FirstEvent), formatTime(feed.LastEvent),
+			formatNullString(feed.Previous), formatNullString(feed.Next))
+	}
+	return tw.Flush()
+}
+
+func runFeed(ctx context.Context, db *sql.DB, flags *flag.FlagSet, out io.Writer) error {
+	if flags.NArg() != 1 {
+		return errUsage
+	}
+
+	var events []esatompub.TimestampedEvent
+	var err error
+	if feedid := flags.Arg(0); feedid == "recent" {
+		events, err = esatompub.RetrieveRecentContext(ctx, db)
+	} else {
+		events, err = esatompub.RetrieveArchiveContext(ctx, db, feedid)
+	}
+	if err != nil {
+		return err
+	}
+
+	return printEvents(out, events, boolFlag(flags, "payload"))
+}
+
+func runEvent(ctx context.Context, db *sql.DB, flags *flag.FlagSet, out io.Writer) error {
+	if flags.NArg() != 2 {
+		return errUsage
+	}
+
+	version, err := strconv.Atoi(flags.Arg(1))
+	if err != nil {
+		return fmt.Errorf("invalid version %s", flags.Arg(1))
+	}
+
+	event, err := esatompub.RetrieveEventContext(ctx, db, flags.Arg(0), version)
+	if err != nil {
+		return err
+	}
+
+	return printEvents(out, []esatompub.TimestampedEvent{event}, boolFlag(flags, "payload"))
+}
+
+func printEvents(out io.Writer, events []esatompub.TimestampedEvent, payload bool) error {
+	tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "SEQUENCE\tTIMESTAMP\tAGGREGATE\tVERSION\tTYPECODE\tFEED")
+	for _, event := range events {
+		feedid := event.FeedID
+		if feedid == "" {
+			feedid = "recent"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%s\t%s\n",
+			event.Sequence, formatTime(event.Timestamp), event.Source, event.Version, event.TypeCode, feedid)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if payload {
+		for _, event := range events {
+			fmt.Fprintf(out, "\n%s %d:\n%s\n", event.Source, event.Version, event.Payload)
+		}
+	}
+
+	return nil
+}
+
+func runStats(ctx context.Context, db *sql.DB, flags *flag.FlagSet, out io.Writer) error {
+	if flags.NArg() != 0 {
+		return errUsage
+	}
+
+	health := esatompub.CheckHealthContext(ctx, db)
+	if health.Status == esatompub.HealthDown {
+		return errors.New(health.DatabaseError)
+	}
+
+	report, err := esatompub.VerifyContext(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	oldest := "-"
+	if health.OldestUnassigned != nil {
+		oldest = fmt.Sprintf("%s (%.0fs ago)", formatTime(*health.OldestUnassigned), health.OldestUnassignedAge)
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "status:\t%s\n", health.Status)
+	fmt.Fprintf(tw, "feeds:\t%d\n", report.Feeds)
+	fmt.Fprintf(tw, "chain length:\t%d\n", report.ChainLength)
+	fmt.Fprintf(tw, "last feed:\t%s\n", report.LastFeed)
+	fmt.Fprintf(tw, "recent events:\t%d\n", health.RecentCount)
+	fmt.Fprintf(tw, "feed threshold:\t%d\n", health.FeedThreshold)
+	fmt.Fprintf(tw, "oldest recent event:\t%s\n", oldest)
+	fmt.Fprintf(tw, "anomalies:\t%d\n", len(report.Anomalies))
+	return tw.Flush()
+}
+
+func runVerify(ctx context.Context, db *sql.DB, flags *flag.FlagSet, out io.Writer) error {
+	if flags.NArg() != 0 {
+		return errUsage
+	}
+
+	repair := boolFlag(flags, "repair")
+
+	var report esatompub.VerifyReport
+	var err error
+	if repair {
+		report, err = esatompub.RepairContext(ctx, db, esatompub.RepairOptions{Repage: boolFlag(flags, "repage")})
+	} else {
+		report, err = esatompub.VerifyContext(ctx, db)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "%d feeds, chain length %d, last feed %s\n", report.Feeds, report.ChainLength, report.LastFeed)
+	for _, anomaly := range report.Anomalies {
+		fmt.Fprintln(out, anomaly)
+	}
+
+	switch {
+	case report.OK():
+		fmt.Fprintln(out, "ok")
+	case repair:
+		fmt.Fprintf(out, "repaired %d anomalies\n", len(report.Anomalies))
+	default:
+		return errAnomalies
+	}
+
+	return nil
+}
+
+func runRollover(ctx context.Context, db *sql.DB, flags *flag.FlagSet, out io.Writer) error {
+	if flags.NArg() != 0 {
+		return errUsage
+	}
+
+	feedid, err := esatompub.ForceRolloverContext(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	if feedid == "" {
+		fmt.Fprintln(out, "recent page is empty, no feed created")
+	} else {
+		fmt.Fprintf(out, "created feed %s\n", feedid)
+	}
+	return nil
+}
+
+func runExport(ctx context.Context, db *sql.DB, flags *flag.FlagSet, out io.Writer) error {
+	if flags.NArg() != 0 {
+		return errUsage
+	}
+
+	path := stringFlag(flags, "o")
+	if path == "-" {
+		_, err := esatompub.ExportContext(ctx, db, out)
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	summary, err := esatompub.ExportContext(ctx, db, f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "exported %d feeds and %d events to %s, checksum %s\n", summary.Feeds, summary.Events, path, summary.Checksum)
+	return nil
+}
+
+func runImport(ctx context.Context, db *sql.DB, flags *flag.FlagSet, out io.Writer) error {
+	if flags.NArg() != 1 {
+		return errUsage
+	}
+
+	var in io.Reader = os.Stdin
+	if path := flags.Arg(0); path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	summary, err := esatompub.ImportContext(ctx, db, in)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "imported %d feeds and %d events, checksum %s\n", summary.Feeds, summary.Events, summary.Checksum)
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFeedsCommand(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	created := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	rows := sqlmock.NewRows([]string{"feedid", "event_time", "previous", "next", "count", "first", "last"}).
+		AddRow("feed-1", created, nil, "feed-2", 100, created, created).
+		AddRow("feed-2", created, "feed-1", nil, 100, created, created)
+	mock.ExpectQuery("select f.feedid").WillReturnRows(rows)
+
+	var stdout, stderr bytes.Buffer
+	status := runCommand(context.Background(), findCommand("feeds"), db, []string{"-limit", "2"}, &stdout, &stderr)
+	assert.Equal(t, 0, status)
+	assert.Nil(t, mock.ExpectationsWereMet())
+
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if assert.Equal(t, 3, len(lines)) {
+		assert.True(t, strings.HasPrefix(lines[1], "feed-1"))
+		assert.Contains(t, lines[2], "feed-1")
+	}
+}
+
+func TestEventCommandUsage(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	var stdout, stderr bytes.Buffer
+	status := runCommand(context.Background(), findCommand("event"), db, []string{"agg1"}, &stdout, &stderr)
+	assert.Equal(t, 2, status)
+	assert.Contains(t, stderr.String(), "usage: esatom event")
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestVerifyCommandFailsOnAnomalies(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("from t_aefd_feed f order by f.id").WillReturnRows(
+		sqlmock.NewRows([]string{"id", "feedid", "previous", "count"}).
+			AddRow(1, "f1", nil, 100).
+			AddRow(2, "f2", "f9", 100))
+	mock.ExpectQuery("select distinct e.feedid").WillReturnRows(sqlmock.NewRows([]string{"feedid"}))
+	mock.ExpectQuery("select count").WillReturnRows(sqlmock.NewRows([]string{"count(*)", "max(id)"}).AddRow(0, nil))
+
+	var stdout, stderr bytes.Buffer
+	status := runCommand(context.Background(), findCommand("verify"), db, nil, &stdout, &stderr)
+	assert.Equal(t, 1, status)
+	assert.Contains(t, stdout.String(), "dangling-previous")
+	assert.Contains(t, stderr.String(), errAnomalies.Error())
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,97 @@
+// Command esatom inspects and administers the atom data tables. It reads the database
+// connection from the DB_USER, DB_PASSWORD, DB_HOST, DB_PORT and DB_SVC environment
+// variables used by the integration tests, and the feed threshold from FEED_THRESHOLD.
+//
+// Usage:
+//
+//	esatom [-v] <command> [arguments]
+//
+// Run esatom without a command to list the commands.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	_ "github.com/mattn/go-oci8"
+	"github.com/xtracdev/es-atom-data"
+	"github.com/xtracdev/es-atom-data/internal/envconfig"
+	"io"
+	"os"
+)
+
+func main() {
+	os.Exit(run(context.Background(), os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("esatom", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	verbose := flags.Bool("v", false, "log the work done by the store")
+	flags.Usage = func() { usage(stderr) }
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if flags.NArg() == 0 {
+		usage(stderr)
+		return 2
+	}
+
+	cmd := findCommand(flags.Arg(0))
+	if cmd == nil {
+		fmt.Fprintf(stderr, "esatom: unknown command %s\n", flags.Arg(0))
+		usage(stderr)
+		return 2
+	}
+
+	log.SetOutput(stderr)
+	if *verbose {
+		log.SetLevel(log.InfoLevel)
+	} else {
+		log.SetLevel(log.WarnLevel)
+	}
+
+	esatompub.ReadFeedThresholdFromEnv()
+
+	db, err := openDB()
+	if err != nil {
+		fmt.Fprintf(stderr, "esatom: %s\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	return runCommand(ctx, cmd, db, flags.Args()[1:], stdout, stderr)
+}
+
+func openDB() (*sql.DB, error) {
+	env, err := envconfig.NewEnvConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Connecting to %s", env.MaskedConnectString())
+
+	db, err := sql.Open("oci8", env.ConnectString())
+	if err != nil {
+		return nil, err
+	}
+
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: esatom [-v] <command> [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+}
@@ -0,0 +1,173 @@
+package esatompub
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/xtracdev/goes"
+	"github.com/xtracdev/orapub"
+	"regexp"
+	"strings"
+)
+
+// Default table names. Configured table names are applied by rewriting these names
+// wherever they appear in a statement, so every sql* statement in the package must
+// spell the tables exactly as below: lower case, unquoted and without a schema.
+const (
+	DefaultEventTable    = "t_aeae_atom_event"
+	DefaultFeedTable     = "t_aefd_feed"
+	DefaultBackfillTable = "t_aebf_backfill"
+)
+
+// tableNamePattern matches an Oracle identifier, optionally qualified by a schema.
+var tableNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_$#]{0,127}(\.[A-Za-z][A-Za-z0-9_$#]{0,127})?$`)
+
+// Config configures a store or processor explicitly, without the package level
+// FeedThreshold, the FEED_THRESHOLD and STATSD_ENDPOINT environment variables or the
+// armon/go-metrics global, so differently configured processors can run in the same
+// process. The zero value is valid and uses the defaults noted.
+type Config struct {
+	// FeedThreshold is the number of events per archive feed. Defaults to 100.
+	FeedThreshold int
+
+	// Metrics receives the telemetry. If nil no telemetry is emitted.
+	Metrics Metrics
+
+	// Logger receives the log messages. Defaults to the standard logrus logger.
+	Logger Logger
+
+	// Notifier receives stored events and new feeds. Defaults to DefaultNotifier.
+	Notifier *Notifier
+
+	// EventTable, FeedTable and BackfillTable name the tables holding the events,
+	// the feed chain and the backfill checkpoints. They default to
+	// t_aeae_atom_event, t_aefd_feed and t_aebf_backfill, and may be qualified by a
+	// schema. Use separate tables to keep separate feed chains.
+	EventTable    string
+	FeedTable     string
+	BackfillTable string
+
+	// FeedName labels the throughput metrics. Defaults to "default".
+	FeedName string
+}
+
+// Validate returns an error describing every invalid setting, or nil.
+func (c Config) Validate() error {
+	var problems []string
+
+	if c.FeedThreshold < 0 {
+		problems = append(problems, fmt.Sprintf("feed threshold must not be negative, got %d", c.FeedThreshold))
+	}
+
+	tables := c.tableNames()
+	seen := make(map[string]string)
+	for _, table := range []struct{ setting, name string }{
+		{"event table", tables[0]},
+		{"feed table", tables[1]},
+		{"backfill table", tables[2]},
+	} {
+		if !tableNamePattern.MatchString(table.name) {
+			problems = append(problems, fmt.Sprintf("%s %q is not a valid table name", table.setting, table.name))
+			continue
+		}
+
+		key := strings.ToLower(table.name)
+		if other, ok := seen[key]; ok {
+			problems = append(problems, fmt.Sprintf("%s and %s are both %s", other, table.setting, table.name))
+		}
+		seen[key] = table.setting
+	}
+
+	if len(problems) != 0 {
+		return errors.New("invalid config: " + strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// tableNames returns the event, feed and backfill table names, with the defaults
+// filled in.
+func (c Config) tableNames() [3]string {
+	names := [3]string{c.EventTable, c.FeedTable, c.BackfillTable}
+	for i, def := range [3]string{DefaultEventTable, DefaultFeedTable, DefaultBackfillTable} {
+		if names[i] == "" {
+			names[i] = def
+		}
+	}
+	return names
+}
+
+// options returns the store options for a valid config.
+func (c Config) options() []StoreOption {
+	threshold := c.FeedThreshold
+	if threshold == 0 {
+		threshold = defaultFeedThreshold
+	}
+
+	opts := []StoreOption{WithFeedThreshold(threshold)}
+
+	//A nil Metrics discards the telemetry
+	opts = append(opts, WithMetrics(c.Metrics))
+
+	if c.Logger != nil {
+		opts = append(opts, WithLogger(c.Logger))
+	}
+	if c.Notifier != nil {
+		opts = append(opts, WithNotifier(c.Notifier))
+	}
+	if c.FeedName != "" {
+		opts = append(opts, WithFeedName(c.FeedName))
+	}
+
+	tables := c.tableNames()
+	if tables != [3]string{DefaultEventTable, DefaultFeedTable, DefaultBackfillTable} {
+		replacer := strings.NewReplacer(
+			DefaultEventTable, tables[0],
+			DefaultFeedTable, tables[1],
+			DefaultBackfillTable, tables[2],
+		)
+		opts = append(opts, func(s *Store) {
+			s.tables = replacer
+		})
+	}
+
+	return opts
+}
+
+// NewStoreWithConfig validates cfg and returns a store using db configured by it,
+// preparing its statements. Further options, such as WithReaderDB, are applied
+// after the config.
+func NewStoreWithConfig(db *sql.DB, cfg Config, opts ...StoreOption) (*Store, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return NewStore(db, append(cfg.options(), opts...)...)
+}
+
+// NewESAtomPubProcessorWithConfig validates cfg and returns a processor configured
+// by it. Unlike NewESAtomPubProcessor, it reads no environment variables and leaves
+// the armon/go-metrics global alone.
+func NewESAtomPubProcessorWithConfig(ctx context.Context, cfg Config) (orapub.EventProcessor, error) {
+	if err := cfg.Validate(); err != nil {
+		return orapub.EventProcessor{}, err
+	}
+
+	opts := cfg.options()
+	return orapub.EventProcessor{
+		Initialize: func(db *sql.DB) error {
+			return nil
+		},
+		Processor: func(db *sql.DB, event *goes.Event) error {
+			return newStore(db, opts...).ProcessEventContext(ctx, event)
+		},
+	}, nil
+}
+
+// discardMetrics drops the telemetry of a store configured without metrics.
+type discardMetrics struct{}
+
+func (discardMetrics) SetGauge(key []string, val float32)    {}
+func (discardMetrics) IncrCounter(key []string, val float32) {}
+func (discardMetrics) AddSample(key []string, val float32)   {}
@@ -0,0 +1,144 @@
+package esatompub
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/goes"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestConfigValidate(t *testing.T) {
+	assert.Nil(t, Config{}.Validate())
+	assert.Nil(t, Config{FeedThreshold: 10, EventTable: "atom.orders_event", FeedTable: "atom.orders_feed"}.Validate())
+
+	err := Config{
+		FeedThreshold: -1,
+		EventTable:    "events; drop table t_aefd_feed",
+		FeedTable:     "T_AEBF_BACKFILL",
+	}.Validate()
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "feed threshold must not be negative")
+		assert.Contains(t, err.Error(), `event table "events; drop table t_aefd_feed" is not a valid table name`)
+		assert.Contains(t, err.Error(), "feed table and backfill table are both t_aebf_backfill")
+	}
+}
+
+func TestNewStoreWithConfigRejectsInvalidConfig(t *testing.T) {
+	_, err := NewStoreWithConfig(nil, Config{FeedThreshold: -5})
+	assert.NotNil(t, err)
+
+	_, err = NewESAtomPubProcessorWithConfig(context.Background(), Config{FeedTable: "1feed"})
+	assert.NotNil(t, err)
+}
+
+func TestProcessorWithConfig(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	//The config threshold, not the package level one, decides on rollover
+	saved := FeedThreshold
+	FeedThreshold = 1
+	defer func() { FeedThreshold = saved }()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("lock table orders_feed in exclusive mode").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`select feedid from orders_feed where id = \(select max\(id\) from orders_feed\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"feedid"}).AddRow("f1"))
+	mock.ExpectExec("insert into orders_event").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("select count\\(\\*\\), max\\(id\\) from orders_event").
+		WillReturnRows(sqlmock.NewRows([]string{"count(*)", "max(id)"}).AddRow(1, 42))
+	mock.ExpectCommit()
+
+	m := newRecordingMetrics()
+	processor, err := NewESAtomPubProcessorWithConfig(context.Background(), Config{
+		Metrics:    m,
+		Notifier:   NewNotifier(),
+		EventTable: "orders_event",
+		FeedTable:  "orders_feed",
+		FeedName:   "orders",
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	err = processor.Processor(db, &goes.Event{Source: "agg1", Version: 1, TypeCode: "foo", Payload: []byte("ok")})
+	assert.Nil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+	assert.Equal(t, 2, waitForCount(m, "es-atom-data.process-event.ok", 2))
+}
+
+func TestConfigTablesAppliedToVerify(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("from orders_feed f order by f.id").WillReturnRows(sqlmock.NewRows(verifyFeedColumns))
+	mock.ExpectQuery("select distinct e.feedid from orders_event e").WillReturnRows(sqlmock.NewRows([]string{"feedid"}))
+	mock.ExpectQuery("from orders_event where feedid is null").WillReturnRows(sqlmock.NewRows([]string{"count(*)", "max(id)"}).AddRow(0, nil))
+
+	cfg := Config{EventTable: "orders_event", FeedTable: "orders_feed"}
+	report, err := newStore(db, cfg.options()...).VerifyContext(context.Background())
+	assert.Nil(t, err)
+	assert.True(t, report.OK())
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+// tableStatements holds every statement naming the atom data tables.
+var tableStatements = []string{
+	sqlSelectAggregate,
+	sqlSelectRecent, sqlSelectForFeed, sqlSelectPreviousFeed, sqlSelectNextFeed,
+	sqlSelectEvent, sqlFeedExists, sqlSelectFirstFeed,
+	sqlLatestFeedId, sqlInsertEventIntoFeed, sqlRecentFeedCount, sqlUpdateFeedIds,
+	sqlInsertFeed, sqlLockTable,
+	sqlBackfillCheckpoint, sqlBackfillCheckpointCount, sqlUpdateBackfillCheckpoint,
+	sqlInsertBackfillCheckpoint, sqlBackfillEvent,
+	sqlExportFeeds, sqlExportFeedEvents, sqlExportRecentEvents, sqlImportTableCounts,
+	sqlImportEvent,
+	sqlSelectFeedInfo, sqlListFeeds,
+	sqlGaugeStats,
+	sqlRecentStats, sqlChainAnomalies,
+	sqlSelectHistory,
+	sqlFeedsAfter,
+	sqlSelectSince, sqlSelectHead,
+	sqlSelectTimeRange, sqlSelectFeedsInRange,
+	sqlVerifyFeeds, sqlOrphanFeedIds, sqlPageBoundaries, sqlAssignPage, sqlUnassignFrom,
+	sqlRepairFeedOrder, sqlDeleteFeeds, sqlInsertFeedCreated,
+}
+
+func TestConfigTablesInEveryStatement(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	cfg := Config{EventTable: "orders.event", FeedTable: "orders.feed", BackfillTable: "orders.backfill"}
+	renamed := strings.NewReplacer(DefaultEventTable, cfg.EventTable, DefaultFeedTable, cfg.FeedTable,
+		DefaultBackfillTable, cfg.BackfillTable)
+	for _, query := range preparedStatements {
+		mock.ExpectPrepare(regexp.QuoteMeta(renamed.Replace(query)) + "$")
+	}
+
+	store, err := NewStoreWithConfig(db, cfg)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Nil(t, mock.ExpectationsWereMet())
+
+	//Catch any spelling of the default names the rewrite would miss
+	for _, query := range tableStatements {
+		rewritten := strings.ToLower(store.sqlFor(query))
+		for _, table := range []string{"t_aeae_", "t_aefd_", "t_aebf_"} {
+			assert.NotContains(t, rewritten, table, query)
+		}
+		assert.Contains(t, rewritten, "orders.", query)
+	}
+}
@@ -0,0 +1,21 @@
+package esatompub
+
+import "errors"
+
+var (
+	// ErrFeedNotFound is returned when a feed id does not exist in the feed table.
+	ErrFeedNotFound = errors.New("feed not found")
+
+	// ErrEventNotFound is returned when no stored event matches the aggregate id and version.
+	ErrEventNotFound = errors.New("event not found")
+
+	// ErrBrokenChain is returned by Export when the feeds don't form a single chain.
+	ErrBrokenChain = errors.New("feed chain is broken, run Verify")
+
+	// ErrImportTargetNotEmpty is returned by Import when the atom data tables hold data.
+	ErrImportTargetNotEmpty = errors.New("import target tables are not empty")
+
+	// ErrBackfillTargetNotEmpty is returned by Backfill when starting without a
+	// checkpoint and the atom data tables hold data.
+	ErrBackfillTargetNotEmpty = errors.New("backfill target tables are not empty")
+)
@@ -0,0 +1,125 @@
+package esatompub
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"testing"
+	"time"
+)
+
+var eventStoreColumns = []string{"aggregate_id", "version", "typecode", "payload", "event_time"}
+
+func TestEventStoreSource(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	stored := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("from t_esdx_events order by event_time, aggregate_id, version fetch next :1 rows only").
+		WithArgs(2).WillReturnRows(sqlmock.NewRows(eventStoreColumns).
+		AddRow("agg1", 3, "foo", []byte("ok"), stored).
+		AddRow("agg 2", 1, "bar", nil, stored))
+	mock.ExpectQuery("from t_esdx_events where event_time > :1").
+		WithArgs(stored, stored, "agg 2", "agg 2", 1, 2).WillReturnRows(sqlmock.NewRows(eventStoreColumns))
+
+	source, err := NewEventStoreSource(db, "")
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	events, err := source.ReadEvents(context.Background(), "", 2)
+	if assert.Nil(t, err) && assert.Equal(t, 2, len(events)) {
+		assert.Equal(t, "2017-03-01T12:00:00Z 3 agg1", events[0].Position)
+		assert.Equal(t, "agg1", events[0].Source)
+		assert.Equal(t, 3, events[0].Version)
+		assert.Equal(t, "foo", events[0].TypeCode)
+		assert.Equal(t, []byte("ok"), events[0].Payload)
+		assert.Equal(t, stored, events[0].Timestamp)
+
+		assert.Equal(t, "2017-03-01T12:00:00Z 1 agg 2", events[1].Position)
+
+		events, err = source.ReadEvents(context.Background(), events[1].Position, 2)
+		assert.Nil(t, err)
+		assert.Empty(t, events)
+	}
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestEventStoreSourceInvalidPosition(t *testing.T) {
+	source, err := NewEventStoreSource(nil, "")
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	_, err = source.ReadEvents(context.Background(), "20", 2)
+	assert.NotNil(t, err)
+}
+
+func TestEventStoreSourceResumesAfterLateInsert(t *testing.T) {
+	source, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer source.Close()
+
+	first := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	second := first.Add(time.Second)
+
+	//The first run copies agg2, the only event so far
+	mock.ExpectQuery("fetch next :1 rows only").WithArgs(500).WillReturnRows(
+		sqlmock.NewRows(eventStoreColumns).AddRow("agg2", 1, "foo", []byte("two"), first))
+	mock.ExpectQuery("where event_time > :1").WithArgs(first, first, "agg2", "agg2", 1, 500).WillReturnRows(
+		sqlmock.NewRows(eventStoreColumns))
+
+	//Before the second run agg1 is inserted with the same event time, sorting ahead of
+	//agg2. Resuming from the key of agg2 reads on from agg3, rather than from a rank
+	//that the insert has shifted.
+	mock.ExpectQuery("where event_time > :1").WithArgs(first, first, "agg2", "agg2", 1, 500).WillReturnRows(
+		sqlmock.NewRows(eventStoreColumns).AddRow("agg3", 1, "foo", []byte("three"), second))
+	mock.ExpectQuery("where event_time > :1").WithArgs(second, second, "agg3", "agg3", 1, 500).WillReturnRows(
+		sqlmock.NewRows(eventStoreColumns))
+
+	target, targetMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer target.Close()
+
+	firstPosition := eventStorePosition(first, "agg2", 1)
+	targetMock.ExpectQuery("select position from t_aebf_backfill").WillReturnRows(sqlmock.NewRows([]string{"position"}))
+	targetMock.ExpectQuery("select count\\(\\*\\) from t_aebf_backfill").WillReturnRows(sqlmock.NewRows([]string{"count(*)"}).AddRow(0))
+	targetMock.ExpectQuery("from dual").WillReturnRows(sqlmock.NewRows([]string{"feeds", "events"}).AddRow(0, 0))
+	expectBackfillBatchStart(targetMock, nil, 0)
+	targetMock.ExpectExec("insert into t_aeae_atom_event").WithArgs("agg2", 1, "foo", []byte("two"), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	targetMock.ExpectExec("insert into t_aebf_backfill").WithArgs("default", firstPosition).WillReturnResult(sqlmock.NewResult(1, 1))
+	targetMock.ExpectCommit()
+
+	targetMock.ExpectQuery("select position from t_aebf_backfill").WillReturnRows(sqlmock.NewRows([]string{"position"}).AddRow(firstPosition))
+	expectBackfillBatchStart(targetMock, nil, 1)
+	targetMock.ExpectExec("insert into t_aeae_atom_event").WithArgs("agg3", 1, "foo", []byte("three"), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(2, 1))
+	targetMock.ExpectExec("update t_aebf_backfill").WithArgs(eventStorePosition(second, "agg3", 1), "default").WillReturnResult(sqlmock.NewResult(0, 1))
+	targetMock.ExpectCommit()
+
+	es, err := NewEventStoreSource(source, "events.t_esdx_events")
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	_, err = Backfill(target, es, BackfillOptions{})
+	assert.Nil(t, err)
+
+	progress, err := Backfill(target, es, BackfillOptions{})
+	if assert.Nil(t, err) {
+		assert.Equal(t, BackfillProgress{Position: eventStorePosition(second, "agg3", 1), Events: 1}, progress)
+	}
+	assert.Nil(t, mock.ExpectationsWereMet())
+	assert.Nil(t, targetMock.ExpectationsWereMet())
+}
+
+func TestEventStoreSourceRejectsInvalidTable(t *testing.T) {
+	_, err := NewEventStoreSource(nil, "t_esdx_events where 1=1")
+	assert.NotNil(t, err)
+}
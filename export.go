@@ -0,0 +1,467 @@
+package esatompub
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"time"
+)
+
+const (
+	sqlSetReadOnly        = `set transaction read only`
+	sqlExportFeeds        = `select feedid, previous, event_time from t_aefd_feed order by id`
+	sqlExportFeedEvents   = `select event_time, aggregate_id, version, typecode, payload from t_aeae_atom_event where feedid = :1 order by id`
+	sqlExportRecentEvents = `select event_time, aggregate_id, version, typecode, payload from t_aeae_atom_event where feedid is null order by id`
+	sqlImportTableCounts  = `select (select count(*) from t_aefd_feed), (select count(*) from t_aeae_atom_event) from dual`
+	sqlImportEvent        = `insert into t_aeae_atom_event (feedid, event_time, aggregate_id, version, typecode, payload) values (:1, :2, :3, :4, :5, :6)`
+)
+
+// ExportFormat is the version of the export format written by Export.
+const ExportFormat = 1
+
+// Export record types.
+const (
+	ExportHeader  = "header"
+	ExportFeed    = "feed"
+	ExportRecent  = "recent"
+	ExportEvent   = "event"
+	ExportTrailer = "trailer"
+)
+
+// ExportRecord is one line of an export. An export starts with a header record, then
+// holds a feed record for each feed in chain order, a recent record for the recent
+// page, and ends with a trailer record. Each feed and recent record is followed by
+// an event record for each of its events, oldest first.
+type ExportRecord struct {
+	Type string `json:"type"`
+
+	// Header
+	Format     int        `json:"format,omitempty"`
+	ExportedAt *time.Time `json:"exported_at,omitempty"`
+
+	// Feed
+	FeedID   string `json:"feed_id,omitempty"`
+	Previous string `json:"previous,omitempty"`
+
+	// Feed and event
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+
+	// Event. Payloads are base64 encoded.
+	AggregateID string `json:"aggregate_id,omitempty"`
+	Version     *int   `json:"version,omitempty"`
+	TypeCode    string `json:"typecode,omitempty"`
+	Payload     []byte `json:"payload,omitempty"`
+
+	// Trailer
+	Feeds  *int `json:"feeds,omitempty"`
+	Events *int `json:"events,omitempty"`
+
+	// Checksum is the hex SHA-256 of every field of an event record, and for the
+	// trailer record of the feed records and the event checksums in export order.
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// ExportSummary describes an export written by Export or read by Import.
+type ExportSummary struct {
+	Feeds    int
+	Events   int
+	Checksum string
+}
+
+// Export writes the feed history to w as newline delimited JSON records.
+func Export(db *sql.DB, w io.Writer) (ExportSummary, error) {
+	return ExportContext(context.Background(), db, w)
+}
+
+// ExportContext is like Export, but the queries are bound to ctx.
+func ExportContext(ctx context.Context, db *sql.DB, w io.Writer) (ExportSummary, error) {
+	return newStore(db).ExportContext(ctx, w)
+}
+
+// ExportContext writes every feed in chain order, from the first feed to the last,
+// followed by the recent page, as the records described by ExportRecord. The
+// queries run on the primary in a transaction started with set transaction read
+// only, which Oracle reads as of the start of the transaction, so a rollover
+// committed during the export is not seen by any of the queries. The oci8 driver
+// ignores the options passed to BeginTx, so the statement is issued explicitly.
+// The processor is not blocked. A chain with forks,
+// dangling links or unreachable feeds can't be exported in order and returns
+// ErrBrokenChain; run Verify and Repair first.
+func (s *Store) ExportContext(ctx context.Context, w io.Writer) (summary ExportSummary, err error) {
+	ctx, span := s.startSpan(ctx, "Export")
+	defer func() { endSpan(span, err) }()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return summary, err
+	}
+	defer s.doRollback(tx)
+
+	if _, err = s.execTx(ctx, tx, sqlSetReadOnly); err != nil {
+		return summary, err
+	}
+
+	feeds, err := s.selectExportFeeds(ctx, tx)
+	if err != nil {
+		return summary, err
+	}
+
+	out := bufio.NewWriter(w)
+	ew := &exportWriter{store: s, enc: json.NewEncoder(out), checksum: sha256.New()}
+
+	now := time.Now()
+	if err = ew.write(ExportRecord{Type: ExportHeader, Format: ExportFormat, ExportedAt: &now}); err != nil {
+		return summary, err
+	}
+
+	for _, feed := range feeds {
+		created := feed.created
+		record := ExportRecord{Type: ExportFeed, FeedID: feed.feedid, Previous: feed.previous.String, Timestamp: &created}
+		if err = ew.write(record); err != nil {
+			return summary, err
+		}
+		writeFeedChecksum(ew.checksum, &record)
+		ew.feeds++
+
+		if err = ew.writeEvents(ctx, tx, sqlExportFeedEvents, feed.feedid); err != nil {
+			return summary, err
+		}
+	}
+
+	if err = ew.write(ExportRecord{Type: ExportRecent}); err != nil {
+		return summary, err
+	}
+	writeChecksumFields(ew.checksum, ExportRecent)
+	if err = ew.writeEvents(ctx, tx, sqlExportRecentEvents); err != nil {
+		return summary, err
+	}
+
+	summary = ew.summary()
+	err = ew.write(ExportRecord{Type: ExportTrailer, Feeds: &summary.Feeds, Events: &summary.Events, Checksum: summary.Checksum})
+	if err != nil {
+		return summary, err
+	}
+
+	s.logger.Info("Exported feed history", "feeds", summary.Feeds, "events", summary.Events)
+	return summary, out.Flush()
+}
+
+type exportFeed struct {
+	feedid   string
+	previous sql.NullString
+	created  time.Time
+}
+
+// selectExportFeeds returns the feeds in chain order, from the first feed to the last.
+func (s *Store) selectExportFeeds(ctx context.Context, tx *sql.Tx) ([]exportFeed, error) {
+	rows, err := s.queryTx(ctx, tx, sqlExportFeeds)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var all []exportFeed
+	for rows.Next() {
+		var feed exportFeed
+		if err = rows.Scan(&feed.feedid, &feed.previous, &feed.created); err != nil {
+			return nil, err
+		}
+		all = append(all, feed)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	next := make(map[string]exportFeed)
+	var first []exportFeed
+	for _, feed := range all {
+		if !feed.previous.Valid {
+			first = append(first, feed)
+			continue
+		}
+		if _, ok := next[feed.previous.String]; ok {
+			return nil, ErrBrokenChain
+		}
+		next[feed.previous.String] = feed
+	}
+
+	if len(all) == 0 {
+		return all, nil
+	}
+	if len(first) != 1 {
+		return nil, ErrBrokenChain
+	}
+
+	chain := []exportFeed{first[0]}
+	for feed, ok := next[first[0].feedid]; ok; feed, ok = next[feed.feedid] {
+		if len(chain) == len(all) {
+			return nil, ErrBrokenChain
+		}
+		chain = append(chain, feed)
+	}
+
+	if len(chain) != len(all) {
+		return nil, ErrBrokenChain
+	}
+
+	return chain, nil
+}
+
+type exportWriter struct {
+	store    *Store
+	enc      *json.Encoder
+	checksum hash.Hash
+	feeds    int
+	events   int
+}
+
+func (ew *exportWriter) write(record ExportRecord) error {
+	return ew.enc.Encode(record)
+}
+
+func (ew *exportWriter) writeEvents(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) error {
+	rows, err := ew.store.queryTx(ctx, tx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var timestamp time.Time
+		var version int
+		record := ExportRecord{Type: ExportEvent, Timestamp: &timestamp, Version: &version}
+		if err = rows.Scan(&timestamp, &record.AggregateID, &version, &record.TypeCode, &record.Payload); err != nil {
+			return err
+		}
+
+		record.Checksum = eventChecksum(&record)
+		ew.checksum.Write([]byte(record.Checksum))
+		if err = ew.write(record); err != nil {
+			return err
+		}
+		ew.events++
+	}
+
+	return rows.Err()
+}
+
+func (ew *exportWriter) summary() ExportSummary {
+	return ExportSummary{
+		Feeds:    ew.feeds,
+		Events:   ew.events,
+		Checksum: hex.EncodeToString(ew.checksum.Sum(nil)),
+	}
+}
+
+// eventChecksum returns the checksum of every field of an event record.
+func eventChecksum(record *ExportRecord) string {
+	h := sha256.New()
+	writeChecksumFields(h, record.AggregateID, strconv.Itoa(*record.Version), record.TypeCode,
+		record.Timestamp.UTC().Format(time.RFC3339Nano), string(record.Payload))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// writeFeedChecksum adds a feed record to the trailer checksum.
+func writeFeedChecksum(h hash.Hash, record *ExportRecord) {
+	writeChecksumFields(h, ExportFeed, record.FeedID, record.Previous,
+		record.Timestamp.UTC().Format(time.RFC3339Nano))
+}
+
+// writeChecksumFields writes each field prefixed by its length, so that moving bytes
+// from one field to the next changes the checksum.
+func writeChecksumFields(h hash.Hash, fields ...string) {
+	for _, field := range fields {
+		fmt.Fprintf(h, "%d:%s,", len(field), field)
+	}
+}
+
+// Import reads an export written by Export into empty atom data tables.
+func Import(db *sql.DB, r io.Reader) (ExportSummary, error) {
+	return ImportContext(context.Background(), db, r)
+}
+
+// ImportContext is like Import, but the work is bound to ctx.
+func ImportContext(ctx context.Context, db *sql.DB, r io.Reader) (ExportSummary, error) {
+	return newStore(db).ImportContext(ctx, r)
+}
+
+// ImportContext rebuilds the feed and event tables from an export written by
+// ExportContext, keeping the feed ids, previous links and timestamps. The import
+// runs in a single transaction under the feed table lock and is rolled back if
+// the tables aren't empty, which returns ErrImportTargetNotEmpty, or if the export
+// is malformed, truncated or fails a checksum, which returns an ImportError. The
+// event sequence positions are assigned afresh by the target database, in the
+// order of the export.
+func (s *Store) ImportContext(ctx context.Context, r io.Reader) (summary ExportSummary, err error) {
+	ctx, span := s.startSpan(ctx, "Import")
+	defer func() { endSpan(span, err) }()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return summary, err
+	}
+
+	summary, err = s.importRecords(ctx, tx, r)
+	if err != nil {
+		s.doRollback(tx)
+		return summary, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return summary, err
+	}
+
+	s.logger.Info("Imported feed history", "feeds", summary.Feeds, "events", summary.Events)
+	return summary, nil
+}
+
+func (s *Store) importRecords(ctx context.Context, tx *sql.Tx, r io.Reader) (ExportSummary, error) {
+	var summary ExportSummary
+
+	if err := s.lockTable(ctx, tx); err != nil {
+		return summary, err
+	}
+
+	var feeds, events int
+	if err := s.queryRowTx(ctx, tx, sqlImportTableCounts).Scan(&feeds, &events); err != nil {
+		return summary, err
+	}
+	if feeds != 0 || events != 0 {
+		return summary, ErrImportTargetNotEmpty
+	}
+
+	dec := json.NewDecoder(bufio.NewReader(r))
+	checksum := sha256.New()
+	seen := make(map[string]bool)
+
+	var line int
+	var page sql.NullString //Feed of the events being read
+	var inPage, inRecent, done bool
+	var last string
+
+	for {
+		var record ExportRecord
+		err := dec.Decode(&record)
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			return summary, &ImportError{Line: line, Err: err}
+		}
+
+		if line == 1 {
+			if record.Type != ExportHeader {
+				return summary, importErrorf(line, "expected a header record, found %q", record.Type)
+			}
+			if record.Format != ExportFormat {
+				return summary, importErrorf(line, "unsupported export format %d", record.Format)
+			}
+			continue
+		}
+
+		if done {
+			return summary, importErrorf(line, "unexpected %q record after the trailer", record.Type)
+		}
+
+		switch record.Type {
+		case ExportFeed:
+			if inRecent {
+				return summary, importErrorf(line, "feed %s follows the recent page", record.FeedID)
+			}
+			if record.FeedID == "" || seen[record.FeedID] {
+				return summary, importErrorf(line, "missing or duplicate feed id %q", record.FeedID)
+			}
+			if record.Previous != last {
+				return summary, importErrorf(line, "feed %s has previous %q, expected %q", record.FeedID, record.Previous, last)
+			}
+			if record.Timestamp == nil {
+				return summary, importErrorf(line, "feed %s has no timestamp", record.FeedID)
+			}
+
+			previous := sql.NullString{String: record.Previous, Valid: record.Previous != ""}
+			if _, err = s.execTx(ctx, tx, sqlInsertFeedCreated, record.FeedID, previous, *record.Timestamp); err != nil {
+				return summary, err
+			}
+
+			writeFeedChecksum(checksum, &record)
+			seen[record.FeedID] = true
+			last = record.FeedID
+			page = sql.NullString{String: record.FeedID, Valid: true}
+			inPage = true
+			summary.Feeds++
+
+		case ExportRecent:
+			if inRecent {
+				return summary, importErrorf(line, "duplicate recent record")
+			}
+			page = sql.NullString{}
+			inPage, inRecent = true, true
+			writeChecksumFields(checksum, ExportRecent)
+
+		case ExportEvent:
+			if !inPage {
+				return summary, importErrorf(line, "event record before any feed or recent record")
+			}
+			if record.AggregateID == "" || record.Version == nil || record.Timestamp == nil {
+				return summary, importErrorf(line, "event record is missing its aggregate id, version or timestamp")
+			}
+			if sum := eventChecksum(&record); sum != record.Checksum {
+				return summary, importErrorf(line, "checksum of %s %d is %s, expected %s",
+					record.AggregateID, *record.Version, sum, record.Checksum)
+			}
+
+			_, err = s.execTx(ctx, tx, sqlImportEvent,
+				page, *record.Timestamp, record.AggregateID, *record.Version, record.TypeCode, record.Payload)
+			if err != nil {
+				return summary, err
+			}
+
+			checksum.Write([]byte(record.Checksum))
+			summary.Events++
+
+		case ExportTrailer:
+			summary.Checksum = hex.EncodeToString(checksum.Sum(nil))
+			if record.Feeds == nil || *record.Feeds != summary.Feeds || record.Events == nil || *record.Events != summary.Events {
+				return summary, importErrorf(line, "trailer counts don't match the %d feeds and %d events read", summary.Feeds, summary.Events)
+			}
+			if record.Checksum != summary.Checksum {
+				return summary, importErrorf(line, "checksum of the feeds and events read is %s, the trailer has %s", summary.Checksum, record.Checksum)
+			}
+			done = true
+
+		default:
+			return summary, importErrorf(line, "unknown record type %q", record.Type)
+		}
+	}
+
+	if !done {
+		return summary, importErrorf(line, "export is truncated, no trailer record found")
+	}
+
+	return summary, nil
+}
+
+// ImportError describes an export Import could not read. Line counts the records
+// read, starting at one.
+type ImportError struct {
+	Line int
+	Err  error
+}
+
+func (e *ImportError) Error() string {
+	return fmt.Sprintf("import record %d: %s", e.Line, e.Err)
+}
+
+func importErrorf(line int, format string, args ...interface{}) error {
+	return &ImportError{Line: line, Err: fmt.Errorf(format, args...)}
+}
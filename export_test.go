@@ -0,0 +1,256 @@
+package esatompub
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"strings"
+	"testing"
+	"time"
+)
+
+var exportEventColumns = []string{"event_time", "aggregate_id", "version", "typecode", "payload"}
+
+func exportFixture(t *testing.T) []byte {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	created := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("set transaction read only").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("select feedid, previous, event_time from t_aefd_feed").WillReturnRows(
+		sqlmock.NewRows([]string{"feedid", "previous", "event_time"}).
+			AddRow("f2", "f1", created).
+			AddRow("f1", nil, created))
+	mock.ExpectQuery("where feedid = :1 order by id").WithArgs("f1").WillReturnRows(
+		sqlmock.NewRows(exportEventColumns).AddRow(created, "agg1", 1, "foo", []byte("one")))
+	mock.ExpectQuery("where feedid = :1 order by id").WithArgs("f2").WillReturnRows(
+		sqlmock.NewRows(exportEventColumns).AddRow(created, "agg1", 2, "foo", []byte("two")))
+	mock.ExpectQuery("where feedid is null order by id").WillReturnRows(
+		sqlmock.NewRows(exportEventColumns).AddRow(created, "agg2", 1, "bar", nil))
+	mock.ExpectRollback()
+
+	var buf bytes.Buffer
+	summary, err := Export(db, &buf)
+	if assert.Nil(t, err) {
+		assert.Equal(t, 2, summary.Feeds)
+		assert.Equal(t, 3, summary.Events)
+	}
+	assert.Nil(t, mock.ExpectationsWereMet())
+
+	return buf.Bytes()
+}
+
+func TestExport(t *testing.T) {
+	var types []string
+	var feeds []string
+	for _, line := range strings.Split(strings.TrimSpace(string(exportFixture(t))), "\n") {
+		var record ExportRecord
+		if assert.Nil(t, json.Unmarshal([]byte(line), &record)) {
+			types = append(types, record.Type)
+			if record.Type == ExportFeed {
+				feeds = append(feeds, record.FeedID)
+			}
+		}
+	}
+
+	assert.Equal(t, []string{"header", "feed", "event", "feed", "event", "recent", "event", "trailer"}, types)
+	assert.Equal(t, []string{"f1", "f2"}, feeds)
+}
+
+func TestExportBrokenChain(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("set transaction read only").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("select feedid, previous, event_time from t_aefd_feed").WillReturnRows(
+		sqlmock.NewRows([]string{"feedid", "previous", "event_time"}).
+			AddRow("f1", nil, time.Now()).
+			AddRow("f2", "f1", time.Now()).
+			AddRow("f3", "f1", time.Now()))
+	mock.ExpectRollback()
+
+	_, err = Export(db, &bytes.Buffer{})
+	assert.Equal(t, ErrBrokenChain, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func expectImportStart(mock sqlmock.Sqlmock, feeds, events int) {
+	mock.ExpectBegin()
+	mock.ExpectExec("lock table t_aefd_feed").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("from dual").WillReturnRows(sqlmock.NewRows([]string{"feeds", "events"}).AddRow(feeds, events))
+}
+
+func TestImport(t *testing.T) {
+	export := exportFixture(t)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	created := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	inserted := sqlmock.NewResult(1, 1)
+
+	expectImportStart(mock, 0, 0)
+	mock.ExpectExec("insert into t_aefd_feed").WithArgs("f1", nil, created).WillReturnResult(inserted)
+	mock.ExpectExec("insert into t_aeae_atom_event").WithArgs("f1", created, "agg1", 1, "foo", []byte("one")).WillReturnResult(inserted)
+	mock.ExpectExec("insert into t_aefd_feed").WithArgs("f2", "f1", created).WillReturnResult(inserted)
+	mock.ExpectExec("insert into t_aeae_atom_event").WithArgs("f2", created, "agg1", 2, "foo", []byte("two")).WillReturnResult(inserted)
+	mock.ExpectExec("insert into t_aeae_atom_event").WithArgs(nil, created, "agg2", 1, "bar", sqlmock.AnyArg()).WillReturnResult(inserted)
+	mock.ExpectCommit()
+
+	summary, err := Import(db, bytes.NewReader(export))
+	if assert.Nil(t, err) {
+		assert.Equal(t, 2, summary.Feeds)
+		assert.Equal(t, 3, summary.Events)
+	}
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestImportTargetNotEmpty(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	expectImportStart(mock, 1, 100)
+	mock.ExpectRollback()
+
+	_, err = Import(db, bytes.NewReader(exportFixture(t)))
+	assert.Equal(t, ErrImportTargetNotEmpty, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestImportChecksumMismatch(t *testing.T) {
+	export := bytes.Replace(exportFixture(t), []byte(`"payload":"dHdv"`), []byte(`"payload":"VFdP"`), 1)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	inserted := sqlmock.NewResult(1, 1)
+	expectImportStart(mock, 0, 0)
+	mock.ExpectExec("insert into t_aefd_feed").WillReturnResult(inserted)
+	mock.ExpectExec("insert into t_aeae_atom_event").WillReturnResult(inserted)
+	mock.ExpectExec("insert into t_aefd_feed").WillReturnResult(inserted)
+	mock.ExpectRollback()
+
+	_, err = Import(db, bytes.NewReader(export))
+	if assert.IsType(t, &ImportError{}, err) {
+		assert.Equal(t, 5, err.(*ImportError).Line)
+		assert.Contains(t, err.Error(), "checksum of agg1 2")
+	}
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestImportEventMetadataTampered(t *testing.T) {
+	fixture := exportFixture(t)
+	for _, tampered := range [][]byte{
+		bytes.Replace(fixture, []byte(`"typecode":"foo","payload":"dHdv"`), []byte(`"typecode":"baz","payload":"dHdv"`), 1),
+		bytes.Replace(fixture, []byte(`"aggregate_id":"agg1","version":2`), []byte(`"aggregate_id":"agg1","version":3`), 1),
+		bytes.Replace(fixture, []byte(`"aggregate_id":"agg1","version":2`), []byte(`"aggregate_id":"agg9","version":2`), 1),
+	} {
+		if !assert.NotEqual(t, fixture, tampered) {
+			continue
+		}
+
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+
+		inserted := sqlmock.NewResult(1, 1)
+		expectImportStart(mock, 0, 0)
+		mock.ExpectExec("insert into t_aefd_feed").WillReturnResult(inserted)
+		mock.ExpectExec("insert into t_aeae_atom_event").WillReturnResult(inserted)
+		mock.ExpectExec("insert into t_aefd_feed").WillReturnResult(inserted)
+		mock.ExpectRollback()
+
+		_, err = Import(db, bytes.NewReader(tampered))
+		if assert.IsType(t, &ImportError{}, err) {
+			assert.Equal(t, 5, err.(*ImportError).Line)
+		}
+		assert.Nil(t, mock.ExpectationsWereMet())
+		db.Close()
+	}
+}
+
+func TestImportFeedsTampered(t *testing.T) {
+	//Renaming a feed throughout keeps the chain intact, so only the trailer catches it
+	tampered := bytes.Replace(exportFixture(t), []byte(`"f1"`), []byte(`"f0"`), -1)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	expectImportStart(mock, 0, 0)
+	for i := 0; i < 5; i++ {
+		mock.ExpectExec("insert into").WillReturnResult(driver.RowsAffected(1))
+	}
+	mock.ExpectRollback()
+
+	_, err = Import(db, bytes.NewReader(tampered))
+	if assert.IsType(t, &ImportError{}, err) {
+		assert.Contains(t, err.Error(), "checksum of the feeds and events read")
+	}
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestImportTruncated(t *testing.T) {
+	export := exportFixture(t)
+	export = export[:bytes.LastIndex(bytes.TrimSpace(export), []byte("\n"))+1]
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	expectImportStart(mock, 0, 0)
+	for i := 0; i < 5; i++ {
+		mock.ExpectExec("insert into").WillReturnResult(driver.RowsAffected(1))
+	}
+	mock.ExpectRollback()
+
+	_, err = Import(db, bytes.NewReader(export))
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "truncated")
+	}
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestExportFailsWithoutReadOnlyTransaction(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("set transaction read only").WillReturnError(errors.New("ORA-01453"))
+	mock.ExpectRollback()
+
+	var buf bytes.Buffer
+	_, err = Export(db, &buf)
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, buf.Len())
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,110 @@
+package feedhttp
+
+import (
+	"fmt"
+	ad "github.com/xtracdev/es-atom-data"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// NewAggregateHandler returns a handler serving the event stream of a single
+// aggregate as an atom feed. The aggregate id is the request path after the prefix
+// set with WithPathPrefix, or without one the last element of the request path. The
+// optional from, to and limit query parameters narrow the versions returned.
+func NewAggregateHandler(reader ad.Reader, opts ...HandlerOption) http.Handler {
+	o := newHandlerOptions(opts)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		aggregateID, ok := aggregateIDFromPath(r.URL.Path, o.prefix)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if aggregateID == "" {
+			http.Error(w, "aggregate id missing from request", http.StatusBadRequest)
+			return
+		}
+
+		opts, err := aggregateQueryOptions(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		events, err := reader.RetrieveAggregateEventsContext(r.Context(), aggregateID, opts...)
+		if err != nil {
+			o.logger.Warn("Error retrieving aggregate events", ad.LogFieldAggregateID, aggregateID, "error", err)
+			http.Error(w, "error retrieving aggregate events", http.StatusInternalServerError)
+			return
+		}
+
+		if len(events) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+
+		feed := newFeed("urn:esaggregate:"+aggregateID, events)
+		feed.Links = []Link{{Rel: "self", Href: r.URL.String()}}
+		writeFeed(w, feed)
+	})
+}
+
+// aggregateIDFromPath returns the aggregate id in urlPath, which is empty for a path
+// ending in a slash. It returns false if urlPath is outside prefix.
+func aggregateIDFromPath(urlPath string, prefix string) (string, bool) {
+	if prefix != "" {
+		if !strings.HasPrefix(urlPath, prefix) {
+			return "", false
+		}
+		return strings.TrimPrefix(strings.TrimPrefix(urlPath, prefix), "/"), true
+	}
+
+	return urlPath[strings.LastIndex(urlPath, "/")+1:], true
+}
+
+func aggregateQueryOptions(r *http.Request) ([]ad.QueryOption, error) {
+	var opts []ad.QueryOption
+
+	from, err := intParam(r, "from")
+	if err != nil {
+		return nil, err
+	}
+
+	to, err := intParam(r, "to")
+	if err != nil {
+		return nil, err
+	}
+
+	if to > 0 && from > to {
+		return nil, fmt.Errorf("invalid version range: from %d is after to %d", from, to)
+	}
+
+	if from > 0 || to > 0 {
+		opts = append(opts, ad.WithVersionRange(from, to))
+	}
+
+	limit, err := intParam(r, "limit")
+	if err != nil {
+		return nil, err
+	}
+
+	if limit > 0 {
+		opts = append(opts, ad.WithLimit(limit))
+	}
+
+	return opts, nil
+}
+
+func intParam(r *http.Request, name string) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, nil
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("invalid value for %s: %s", name, value)
+	}
+
+	return i, nil
+}
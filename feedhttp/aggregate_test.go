@@ -0,0 +1,107 @@
+package feedhttp
+
+import (
+	"encoding/xml"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	ad "github.com/xtracdev/es-atom-data"
+	"github.com/xtracdev/goes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAggregateHandler(t *testing.T) {
+	ts := time.Now()
+	reader := &fakeReader{
+		aggregate: []ad.TimestampedEvent{
+			{Event: goes.Event{Source: "agg1", Version: 2, TypeCode: "foo", Payload: []byte("two")}, Timestamp: ts, Sequence: 1},
+			{Event: goes.Event{Source: "agg1", Version: 3, TypeCode: "bar", Payload: []byte("three")}, Timestamp: ts, Sequence: 2},
+		},
+	}
+
+	req := httptest.NewRequest("GET", "/aggregates/agg1?from=2&limit=10", nil)
+	rec := httptest.NewRecorder()
+	NewAggregateHandler(reader).ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/atom+xml", rec.Header().Get("Content-Type"))
+	assert.Equal(t, 2, reader.aggregateOpts)
+
+	var feed Feed
+	err := xml.Unmarshal(rec.Body.Bytes(), &feed)
+	if assert.Nil(t, err) && assert.Equal(t, 2, len(feed.Entries)) {
+		assert.Equal(t, "urn:esaggregate:agg1", feed.ID)
+		assert.Equal(t, "urn:esid:agg1:2", feed.Entries[0].ID)
+		assert.Equal(t, "bar", feed.Entries[1].Category.Term)
+		assert.Equal(t, "dGhyZWU=", feed.Entries[1].Content.Body)
+	}
+}
+
+func TestAggregateHandlerBadParam(t *testing.T) {
+	req := httptest.NewRequest("GET", "/aggregates/agg1?limit=lots", nil)
+	rec := httptest.NewRecorder()
+	NewAggregateHandler(&fakeReader{}).ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestAggregateHandlerFromAfterTo(t *testing.T) {
+	req := httptest.NewRequest("GET", "/aggregates/agg1?from=5&to=3", nil)
+	rec := httptest.NewRecorder()
+	NewAggregateHandler(&fakeReader{}).ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestAggregateHandlerMissingID(t *testing.T) {
+	for _, handler := range []http.Handler{
+		NewAggregateHandler(&fakeReader{}),
+		NewAggregateHandler(&fakeReader{}, WithPathPrefix("/aggregates/")),
+	} {
+		req := httptest.NewRequest("GET", "/aggregates/", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestAggregateHandlerPathPrefix(t *testing.T) {
+	reader := &fakeReader{
+		aggregate: []ad.TimestampedEvent{
+			{Event: goes.Event{Source: "orders/17", Version: 1, TypeCode: "foo", Payload: []byte("one")}, Sequence: 1},
+		},
+	}
+	handler := NewAggregateHandler(reader, WithPathPrefix("/aggregates"))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/aggregates/orders/17", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var feed Feed
+	if assert.Nil(t, xml.Unmarshal(rec.Body.Bytes(), &feed)) {
+		assert.Equal(t, "urn:esaggregate:orders/17", feed.ID)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/feeds/agg1", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestAggregateHandlerNotFound(t *testing.T) {
+	req := httptest.NewRequest("GET", "/aggregates/agg1", nil)
+	rec := httptest.NewRecorder()
+	NewAggregateHandler(&fakeReader{}).ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestAggregateHandlerQueryError(t *testing.T) {
+	req := httptest.NewRequest("GET", "/aggregates/agg1", nil)
+	rec := httptest.NewRecorder()
+	NewAggregateHandler(&fakeReader{err: errors.New("boom")}).ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+}
@@ -0,0 +1,104 @@
+// Package feedhttp serves the data stored by the atom processor over HTTP.
+package feedhttp
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	ad "github.com/xtracdev/es-atom-data"
+	"net/http"
+	"time"
+)
+
+// Feed is the atom representation of a page of events.
+type Feed struct {
+	XMLName xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string   `xml:"id"`
+	Title   string   `xml:"title"`
+	Updated string   `xml:"updated"`
+	Links   []Link   `xml:"link"`
+	Entries []Entry  `xml:"entry"`
+}
+
+// Link is an atom link element.
+type Link struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+}
+
+// Category carries the event type code of an entry.
+type Category struct {
+	Term string `xml:"term,attr"`
+}
+
+// Content holds the base64 encoded event payload.
+type Content struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// Entry is the atom representation of a single event.
+type Entry struct {
+	ID       string   `xml:"id"`
+	Title    string   `xml:"title"`
+	Updated  string   `xml:"updated"`
+	Category Category `xml:"category"`
+	Content  Content  `xml:"content"`
+}
+
+func newEntry(event ad.TimestampedEvent) Entry {
+	return Entry{
+		ID:       fmt.Sprintf("urn:esid:%s:%d", event.Source, event.Version),
+		Title:    "event",
+		Updated:  event.Timestamp.Format(time.RFC3339Nano),
+		Category: Category{Term: event.TypeCode},
+		Content: Content{
+			Type: "application/octet-stream",
+			Body: base64.StdEncoding.EncodeToString(payloadBytes(event.Payload)),
+		},
+	}
+}
+
+func payloadBytes(payload interface{}) []byte {
+	switch p := payload.(type) {
+	case []byte:
+		return p
+	case string:
+		return []byte(p)
+	default:
+		return nil
+	}
+}
+
+func newFeed(id string, events []ad.TimestampedEvent) *Feed {
+	feed := &Feed{
+		ID:    id,
+		Title: "event feed",
+	}
+
+	var updated time.Time
+	for _, event := range events {
+		feed.Entries = append(feed.Entries, newEntry(event))
+		if event.Timestamp.After(updated) {
+			updated = event.Timestamp
+		}
+	}
+
+	if updated.IsZero() {
+		updated = time.Now()
+	}
+	feed.Updated = updated.Format(time.RFC3339Nano)
+
+	return feed
+}
+
+func writeFeed(w http.ResponseWriter, feed *Feed) {
+	out, err := xml.Marshal(feed)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml")
+	w.Write(out)
+}
@@ -0,0 +1,114 @@
+package feedhttp
+
+import (
+	"errors"
+	ad "github.com/xtracdev/es-atom-data"
+	"net/http"
+	"path"
+)
+
+// RecentPage is the path element identifying the recent page rather than an archive.
+const RecentPage = "recent"
+
+// NewFeedHandler returns a handler serving the recent page and the archived feed
+// pages as atom feeds. The last element of the request path selects the page,
+// either RecentPage or an archive feed id; links to other pages are formed relative
+// to the request path. Every page links to the first archive so catch-up readers can
+// page forward from the start of history.
+func NewFeedHandler(reader ad.Reader, opts ...HandlerOption) http.Handler {
+	o := newHandlerOptions(opts)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := path.Base(r.URL.Path)
+		base := path.Dir(r.URL.Path)
+
+		var feed *Feed
+		var err error
+		if id == RecentPage {
+			feed, err = recentFeed(r, reader, base)
+		} else {
+			feed, err = archiveFeed(r, reader, base, id)
+		}
+
+		if errors.Is(err, ad.ErrFeedNotFound) {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			o.logger.Warn("Error retrieving feed", ad.LogFieldFeedID, id, "error", err)
+			http.Error(w, "error retrieving feed", http.StatusInternalServerError)
+			return
+		}
+
+		writeFeed(w, feed)
+	})
+}
+
+func recentFeed(r *http.Request, reader ad.Reader, base string) (*Feed, error) {
+	ctx := r.Context()
+
+	events, err := reader.RetrieveRecentContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	last, err := reader.RetrieveLastFeedContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	first, err := reader.RetrieveFirstFeedContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	feed := newFeed("urn:esfeed:"+RecentPage, events)
+	feed.Links = append(feed.Links, Link{Rel: "self", Href: path.Join(base, RecentPage)})
+	if last != "" {
+		feed.Links = append(feed.Links, Link{Rel: "prev-archive", Href: path.Join(base, last)})
+	}
+	if first != "" {
+		feed.Links = append(feed.Links, Link{Rel: "first", Href: path.Join(base, first)})
+	}
+
+	return feed, nil
+}
+
+func archiveFeed(r *http.Request, reader ad.Reader, base string, feedid string) (*Feed, error) {
+	ctx := r.Context()
+
+	events, err := reader.RetrieveArchiveContext(ctx, feedid)
+	if err != nil {
+		return nil, err
+	}
+
+	previous, err := reader.RetrievePreviousFeedContext(ctx, feedid)
+	if err != nil {
+		return nil, err
+	}
+
+	next, err := reader.RetrieveNextFeedContext(ctx, feedid)
+	if err != nil {
+		return nil, err
+	}
+
+	first, err := reader.RetrieveFirstFeedContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	feed := newFeed("urn:esfeed:"+feedid, events)
+	feed.Links = append(feed.Links,
+		Link{Rel: "self", Href: path.Join(base, feedid)},
+		Link{Rel: "current", Href: path.Join(base, RecentPage)},
+	)
+	if previous.Valid {
+		feed.Links = append(feed.Links, Link{Rel: "prev-archive", Href: path.Join(base, previous.String)})
+	}
+	if next.Valid {
+		feed.Links = append(feed.Links, Link{Rel: "next-archive", Href: path.Join(base, next.String)})
+	}
+	if first != "" {
+		feed.Links = append(feed.Links, Link{Rel: "first", Href: path.Join(base, first)})
+	}
+
+	return feed, nil
+}
@@ -0,0 +1,92 @@
+package feedhttp
+
+import (
+	"encoding/xml"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	ad "github.com/xtracdev/es-atom-data"
+	"github.com/xtracdev/goes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func linkHref(feed Feed, rel string) string {
+	for _, link := range feed.Links {
+		if link.Rel == rel {
+			return link.Href
+		}
+	}
+	return ""
+}
+
+func TestFeedHandlerRecent(t *testing.T) {
+	reader := &fakeReader{
+		recent: []ad.TimestampedEvent{
+			{Event: goes.Event{Source: "agg1", Version: 1, TypeCode: "foo", Payload: []byte("one")}, Timestamp: time.Now(), Sequence: 1},
+		},
+		last:  "feed-3",
+		first: "feed-1",
+	}
+
+	req := httptest.NewRequest("GET", "/notifications/recent", nil)
+	rec := httptest.NewRecorder()
+	NewFeedHandler(reader).ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var feed Feed
+	err := xml.Unmarshal(rec.Body.Bytes(), &feed)
+	if assert.Nil(t, err) {
+		assert.Equal(t, 1, len(feed.Entries))
+		assert.Equal(t, "/notifications/recent", linkHref(feed, "self"))
+		assert.Equal(t, "/notifications/feed-3", linkHref(feed, "prev-archive"))
+		assert.Equal(t, "/notifications/feed-1", linkHref(feed, "first"))
+	}
+}
+
+func TestFeedHandlerArchive(t *testing.T) {
+	reader := &fakeReader{
+		archives: map[string][]ad.TimestampedEvent{
+			"feed-2": {
+				{Event: goes.Event{Source: "agg1", Version: 1, TypeCode: "foo", Payload: []byte("one")}, Timestamp: time.Now(), Sequence: 2, FeedID: "feed-2"},
+			},
+		},
+		previous: map[string]string{"feed-2": "feed-1"},
+		next:     map[string]string{"feed-2": "feed-3"},
+		first:    "feed-1",
+	}
+
+	req := httptest.NewRequest("GET", "/notifications/feed-2", nil)
+	rec := httptest.NewRecorder()
+	NewFeedHandler(reader).ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var feed Feed
+	err := xml.Unmarshal(rec.Body.Bytes(), &feed)
+	if assert.Nil(t, err) {
+		assert.Equal(t, "/notifications/feed-2", linkHref(feed, "self"))
+		assert.Equal(t, "/notifications/recent", linkHref(feed, "current"))
+		assert.Equal(t, "/notifications/feed-1", linkHref(feed, "prev-archive"))
+		assert.Equal(t, "/notifications/feed-3", linkHref(feed, "next-archive"))
+		assert.Equal(t, "/notifications/feed-1", linkHref(feed, "first"))
+	}
+}
+
+func TestFeedHandlerArchiveNotFound(t *testing.T) {
+	req := httptest.NewRequest("GET", "/notifications/nope", nil)
+	rec := httptest.NewRecorder()
+	NewFeedHandler(&fakeReader{}).ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestFeedHandlerError(t *testing.T) {
+	req := httptest.NewRequest("GET", "/notifications/recent", nil)
+	rec := httptest.NewRecorder()
+	NewFeedHandler(&fakeReader{err: errors.New("boom")}).ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+}
@@ -0,0 +1,44 @@
+package feedhttp
+
+import (
+	"encoding/json"
+	ad "github.com/xtracdev/es-atom-data"
+	"net/http"
+)
+
+// NewLivenessHandler returns a handler reporting that the process is serving requests.
+// It doesn't touch the database, so a database outage doesn't get a healthy process
+// restarted; use the readiness handler for that.
+func NewLivenessHandler(opts ...HandlerOption) http.Handler {
+	o := newHandlerOptions(opts)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, o.logger, http.StatusOK, map[string]ad.HealthStatus{"status": ad.HealthOK})
+	})
+}
+
+// NewReadinessHandler returns a handler serving the checker's health report as JSON.
+// The response status is 503 Service Unavailable when the database is down, and
+// 200 OK otherwise, including when the report flags anomalies in the feed data.
+func NewReadinessHandler(checker ad.HealthChecker, opts ...HandlerOption) http.Handler {
+	o := newHandlerOptions(opts)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		health := checker.HealthContext(r.Context())
+
+		status := http.StatusOK
+		if health.Status == ad.HealthDown {
+			o.logger.Warn("Readiness check failed", "error", health.DatabaseError)
+			status = http.StatusServiceUnavailable
+		}
+
+		writeJSON(w, o.logger, status, health)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, logger ad.Logger, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Warn("Error writing response", "error", err)
+	}
+}
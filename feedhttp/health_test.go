@@ -0,0 +1,76 @@
+package feedhttp
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	ad "github.com/xtracdev/es-atom-data"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+type fakeChecker ad.Health
+
+func (f fakeChecker) HealthContext(ctx context.Context) ad.Health {
+	return ad.Health(f)
+}
+
+func TestLivenessHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	NewLivenessHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/health/live", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+}
+
+func TestReadinessHandlerDegraded(t *testing.T) {
+	checker := fakeChecker{Status: ad.HealthDegraded, RecentCount: 3, Anomalies: []string{"fork"}}
+
+	rec := httptest.NewRecorder()
+	NewReadinessHandler(checker).ServeHTTP(rec, httptest.NewRequest("GET", "/health/ready", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var health ad.Health
+	err := json.Unmarshal(rec.Body.Bytes(), &health)
+	if assert.Nil(t, err) {
+		assert.Equal(t, ad.HealthDegraded, health.Status)
+		assert.Equal(t, 3, health.RecentCount)
+		assert.Equal(t, []string{"fork"}, health.Anomalies)
+	}
+}
+
+func TestReadinessHandlerDown(t *testing.T) {
+	checker := fakeChecker{Status: ad.HealthDown, DatabaseError: "ORA-12541: TNS:no listener"}
+
+	logger := &recordingLogger{}
+	rec := httptest.NewRecorder()
+	NewReadinessHandler(checker, WithLogger(logger)).ServeHTTP(rec, httptest.NewRequest("GET", "/health/ready", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, []string{"Readiness check failed"}, logger.messages())
+}
+
+type recordingLogger struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (l *recordingLogger) record(msg string) {
+	l.mu.Lock()
+	l.msgs = append(l.msgs, msg)
+	l.mu.Unlock()
+}
+
+func (l *recordingLogger) messages() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.msgs...)
+}
+
+func (l *recordingLogger) Debug(msg string, fields ...interface{}) { l.record(msg) }
+func (l *recordingLogger) Info(msg string, fields ...interface{})  { l.record(msg) }
+func (l *recordingLogger) Warn(msg string, fields ...interface{})  { l.record(msg) }
+func (l *recordingLogger) Error(msg string, fields ...interface{}) { l.record(msg) }
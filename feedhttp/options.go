@@ -0,0 +1,37 @@
+package feedhttp
+
+import (
+	ad "github.com/xtracdev/es-atom-data"
+)
+
+// HandlerOption configures a handler.
+type HandlerOption func(*handlerOptions)
+
+type handlerOptions struct {
+	logger ad.Logger
+	prefix string
+}
+
+// WithLogger sets the logger the handler writes errors to. By default the package
+// default logger of es-atom-data is used.
+func WithLogger(l ad.Logger) HandlerOption {
+	return func(o *handlerOptions) {
+		o.logger = l
+	}
+}
+
+// WithPathPrefix sets the path the handler is mounted under, such as "/aggregates/".
+// The aggregate handler takes the rest of the request path as the aggregate id.
+func WithPathPrefix(prefix string) HandlerOption {
+	return func(o *handlerOptions) {
+		o.prefix = prefix
+	}
+}
+
+func newHandlerOptions(opts []HandlerOption) *handlerOptions {
+	o := &handlerOptions{logger: ad.DefaultLogger()}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
@@ -0,0 +1,74 @@
+package feedhttp
+
+import (
+	ad "github.com/xtracdev/es-atom-data"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+)
+
+// NewLongPollHandler returns a handler serving the recent page. When the request
+// carries an after parameter holding the sequence position of the newest event the
+// client has seen, the response is held until a newer event is stored or maxWait
+// elapses, whichever comes first.
+func NewLongPollHandler(reader ad.Reader, notifier *ad.Notifier, maxWait time.Duration, opts ...HandlerOption) http.Handler {
+	o := newHandlerOptions(opts)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		base := path.Dir(r.URL.Path)
+
+		var after int64
+		if value := r.URL.Query().Get("after"); value != "" {
+			var err error
+			after, err = strconv.ParseInt(value, 10, 64)
+			if err != nil || after < 0 {
+				http.Error(w, "invalid value for after: "+value, http.StatusBadRequest)
+				return
+			}
+		}
+
+		if after > 0 {
+			if err := waitForEventsAfter(r, reader, notifier, after, maxWait); err != nil {
+				o.logger.Warn("Error waiting for events", "after", after, "error", err)
+				http.Error(w, "error retrieving feed", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		feed, err := recentFeed(r, reader, base)
+		if err != nil {
+			o.logger.Warn("Error retrieving recent feed", "error", err)
+			http.Error(w, "error retrieving feed", http.StatusInternalServerError)
+			return
+		}
+
+		writeFeed(w, feed)
+	})
+}
+
+func waitForEventsAfter(r *http.Request, reader ad.Reader, notifier *ad.Notifier, after int64, maxWait time.Duration) error {
+	//Subscribe before looking so an event stored in between isn't missed
+	sub := notifier.Subscribe(1, ad.DropWhenFull)
+	defer sub.Close()
+
+	events, _, err := reader.RetrieveSinceContext(r.Context(), after, 1)
+	if err != nil || len(events) > 0 {
+		return err
+	}
+
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+
+	for {
+		select {
+		case n := <-sub.C:
+			if n.Kind == ad.EventStored && n.Event.Sequence > after {
+				return nil
+			}
+		case <-timer.C:
+			return nil
+		case <-r.Context().Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package feedhttp
+
+import (
+	"github.com/stretchr/testify/assert"
+	ad "github.com/xtracdev/es-atom-data"
+	"github.com/xtracdev/goes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func storedEvent(sequence int64, aggregateID string, payload string) ad.TimestampedEvent {
+	return ad.TimestampedEvent{
+		Event:     goes.Event{Source: aggregateID, Version: 1, TypeCode: "foo", Payload: []byte(payload)},
+		Timestamp: time.Now(),
+		Sequence:  sequence,
+	}
+}
+
+func TestLongPollReturnsImmediatelyWhenBehind(t *testing.T) {
+	event := storedEvent(8, "agg1", "one")
+	reader := &fakeReader{recent: []ad.TimestampedEvent{event}, history: []ad.TimestampedEvent{event}}
+
+	req := httptest.NewRequest("GET", "/notifications/recent?after=7", nil)
+	rec := httptest.NewRecorder()
+	NewLongPollHandler(reader, ad.NewNotifier(), time.Minute).ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []int64{7}, reader.sinceCalls)
+}
+
+func TestLongPollWaitsForNotification(t *testing.T) {
+	event := storedEvent(8, "agg1", "one")
+	reader := &fakeReader{recent: []ad.TimestampedEvent{event}, history: []ad.TimestampedEvent{event}}
+
+	notifier := ad.NewNotifier()
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		notifier.Publish(ad.Notification{Kind: ad.EventStored, Event: ad.TimestampedEvent{Sequence: 9}})
+	}()
+
+	start := time.Now()
+	req := httptest.NewRequest("GET", "/notifications/recent?after=8", nil)
+	rec := httptest.NewRecorder()
+	NewLongPollHandler(reader, notifier, time.Minute).ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, time.Now().Sub(start) < 30*time.Second)
+}
+
+func TestLongPollTimesOut(t *testing.T) {
+	event := storedEvent(8, "agg1", "one")
+	reader := &fakeReader{recent: []ad.TimestampedEvent{event}, history: []ad.TimestampedEvent{event}}
+
+	req := httptest.NewRequest("GET", "/notifications/recent?after=8", nil)
+	rec := httptest.NewRecorder()
+	NewLongPollHandler(reader, ad.NewNotifier(), 10*time.Millisecond).ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []int64{8}, reader.sinceCalls)
+}
+
+func TestLongPollBadAfter(t *testing.T) {
+	req := httptest.NewRequest("GET", "/notifications/recent?after=soon", nil)
+	rec := httptest.NewRecorder()
+	NewLongPollHandler(&fakeReader{}, ad.NewNotifier(), time.Minute).ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
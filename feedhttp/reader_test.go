@@ -0,0 +1,99 @@
+package feedhttp
+
+import (
+	"context"
+	"database/sql"
+	ad "github.com/xtracdev/es-atom-data"
+)
+
+// fakeReader serves canned data. Queries the tests don't set up panic via the nil
+// embedded Reader.
+type fakeReader struct {
+	ad.Reader
+
+	err       error
+	recent    []ad.TimestampedEvent
+	archives  map[string][]ad.TimestampedEvent
+	previous  map[string]string
+	next      map[string]string
+	first     string
+	last      string
+	aggregate []ad.TimestampedEvent
+	history   []ad.TimestampedEvent
+
+	head        int64
+	headCalled  chan struct{}
+	headRelease chan struct{}
+
+	aggregateOpts int
+	sinceCalls    []int64
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func (f *fakeReader) RetrieveRecentContext(ctx context.Context) ([]ad.TimestampedEvent, error) {
+	return f.recent, f.err
+}
+
+func (f *fakeReader) RetrieveArchiveContext(ctx context.Context, feedid string) ([]ad.TimestampedEvent, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	events, ok := f.archives[feedid]
+	if !ok {
+		return nil, ad.ErrFeedNotFound
+	}
+	return events, nil
+}
+
+func (f *fakeReader) RetrievePreviousFeedContext(ctx context.Context, id string) (sql.NullString, error) {
+	return nullString(f.previous[id]), f.err
+}
+
+func (f *fakeReader) RetrieveNextFeedContext(ctx context.Context, feedId string) (sql.NullString, error) {
+	return nullString(f.next[feedId]), f.err
+}
+
+func (f *fakeReader) RetrieveFirstFeedContext(ctx context.Context) (string, error) {
+	return f.first, f.err
+}
+
+func (f *fakeReader) RetrieveLastFeedContext(ctx context.Context) (string, error) {
+	return f.last, f.err
+}
+
+func (f *fakeReader) RetrieveAggregateEventsContext(ctx context.Context, aggregateID string, opts ...ad.QueryOption) ([]ad.TimestampedEvent, error) {
+	f.aggregateOpts = len(opts)
+	return f.aggregate, f.err
+}
+
+func (f *fakeReader) RetrieveSinceContext(ctx context.Context, position int64, limit int) ([]ad.TimestampedEvent, bool, error) {
+	f.sinceCalls = append(f.sinceCalls, position)
+	if f.err != nil {
+		return nil, false, f.err
+	}
+
+	var events []ad.TimestampedEvent
+	for _, event := range f.history {
+		if event.Sequence <= position {
+			continue
+		}
+		if len(events) == limit {
+			return events, false, nil
+		}
+		events = append(events, event)
+	}
+
+	return events, true, nil
+}
+
+func (f *fakeReader) RetrieveHeadContext(ctx context.Context) (int64, error) {
+	if f.headCalled != nil {
+		close(f.headCalled)
+		<-f.headRelease
+	}
+	return f.head, f.err
+}
@@ -0,0 +1,168 @@
+package feedhttp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	ad "github.com/xtracdev/es-atom-data"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	sseBufferSize    = 100
+	sseCatchUpPage   = 100
+	sseKeepAliveTime = 30 * time.Second
+)
+
+type sseEvent struct {
+	AggregateID string    `json:"aggregate_id"`
+	Version     int       `json:"version"`
+	TypeCode    string    `json:"typecode"`
+	Timestamp   time.Time `json:"timestamp"`
+	FeedID      string    `json:"feedid,omitempty"`
+	Payload     []byte    `json:"payload"`
+}
+
+// NewSSEHandler returns a handler streaming newly stored events to the client as
+// Server-Sent Events. Each message id is the event's sequence position, so a client
+// reconnecting with a Last-Event-ID header is first sent the events it missed, read
+// from reader, before the live stream resumes. A client without one starts from the
+// newest event at the time it connects. Live events come from notifier, so the
+// handler must run in the same process as the processor.
+func NewSSEHandler(reader ad.Reader, notifier *ad.Notifier, opts ...HandlerOption) http.Handler {
+	o := newHandlerOptions(opts)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming not supported", http.StatusInternalServerError)
+			return
+		}
+
+		var last int64
+		value := r.Header.Get("Last-Event-ID")
+		if value != "" {
+			var err error
+			last, err = strconv.ParseInt(value, 10, 64)
+			if err != nil || last < 0 {
+				http.Error(w, "invalid Last-Event-ID: "+value, http.StatusBadRequest)
+				return
+			}
+		}
+
+		//Subscribe before catching up so nothing stored in between is lost
+		sub := notifier.Subscribe(sseBufferSize, ad.DropWhenFull)
+		defer sub.Close()
+
+		//A new client starts at the head, so filling a gap later doesn't replay history
+		if value == "" {
+			var err error
+			last, err = reader.RetrieveHeadContext(r.Context())
+			if err != nil {
+				o.logger.Warn("Error retrieving head for SSE client", "error", err)
+				http.Error(w, "error retrieving events", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		s := &sseStream{w: w, flusher: flusher, last: last}
+
+		if value != "" {
+			if err := s.catchUp(r.Context(), reader); err != nil {
+				o.logger.Warn("Error catching up SSE client", "from", last, "error", err)
+				return
+			}
+		}
+
+		keepAlive := time.NewTicker(sseKeepAliveTime)
+		defer keepAlive.Stop()
+
+		var dropped uint64
+		for {
+			select {
+			case n := <-sub.C:
+				//Notifications were lost while the client was slow - fill the gap from reader
+				if d := sub.Dropped(); d != dropped {
+					dropped = d
+					if err := s.catchUp(r.Context(), reader); err != nil {
+						o.logger.Warn("Error catching up SSE client", "from", s.last, "error", err)
+						return
+					}
+				}
+
+				if n.Kind != ad.EventStored {
+					continue
+				}
+
+				if err := s.send(n.Event); err != nil {
+					return
+				}
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+					return
+				}
+				flusher.Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+}
+
+type sseStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	last    int64
+}
+
+func (s *sseStream) catchUp(ctx context.Context, reader ad.Reader) error {
+	for {
+		events, atHead, err := reader.RetrieveSinceContext(ctx, s.last, sseCatchUpPage)
+		if err != nil {
+			return err
+		}
+
+		for _, event := range events {
+			if err := s.send(event); err != nil {
+				return err
+			}
+		}
+
+		if atHead {
+			return nil
+		}
+	}
+}
+
+func (s *sseStream) send(event ad.TimestampedEvent) error {
+	//Skip anything already sent during catch up
+	if event.Sequence <= s.last {
+		return nil
+	}
+
+	data, err := json.Marshal(sseEvent{
+		AggregateID: event.Source,
+		Version:     event.Version,
+		TypeCode:    event.TypeCode,
+		Timestamp:   event.Timestamp,
+		FeedID:      event.FeedID,
+		Payload:     payloadBytes(event.Payload),
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err = fmt.Fprintf(s.w, "id: %d\ndata: %s\n\n", event.Sequence, data); err != nil {
+		return err
+	}
+
+	s.flusher.Flush()
+	s.last = event.Sequence
+	return nil
+}
@@ -0,0 +1,156 @@
+package feedhttp
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	ad "github.com/xtracdev/es-atom-data"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func readSSEMessage(t *testing.T, reader *bufio.Reader) (string, sseEvent) {
+	var id string
+	var event sseEvent
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("error reading event stream: %s", err)
+		}
+
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "":
+			return id, event
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event)
+			assert.Nil(t, err)
+		}
+	}
+}
+
+func TestSSEStreamsNotifications(t *testing.T) {
+	notifier := ad.NewNotifier()
+	server := httptest.NewServer(NewSSEHandler(&fakeReader{}, notifier))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	//The handler subscribes before sending the response headers
+	notifier.Publish(ad.Notification{Kind: ad.FeedCreated, FeedID: "feed-1"})
+	notifier.Publish(ad.Notification{
+		Kind: ad.EventStored,
+		Event: ad.TimestampedEvent{
+			Sequence: 12,
+		},
+	})
+
+	id, _ := readSSEMessage(t, bufio.NewReader(resp.Body))
+	assert.Equal(t, "12", id)
+}
+
+func TestSSEResumesFromLastEventID(t *testing.T) {
+	archived := storedEvent(6, "agg1", "six")
+	archived.FeedID = "feed-1"
+	store := &fakeReader{
+		history: []ad.TimestampedEvent{
+			storedEvent(5, "agg1", "five"),
+			archived,
+			storedEvent(7, "agg2", "seven"),
+		},
+	}
+
+	notifier := ad.NewNotifier()
+	server := httptest.NewServer(NewSSEHandler(store, notifier))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("Last-Event-ID", "5")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	reader := bufio.NewReader(resp.Body)
+	id, event := readSSEMessage(t, reader)
+	assert.Equal(t, "6", id)
+	assert.Equal(t, "agg1", event.AggregateID)
+	assert.Equal(t, []byte("six"), event.Payload)
+	assert.Equal(t, "feed-1", event.FeedID)
+
+	id, _ = readSSEMessage(t, reader)
+	assert.Equal(t, "7", id)
+
+	//Already sent during catch up, so only the newer event should arrive
+	notifier.Publish(ad.Notification{Kind: ad.EventStored, Event: ad.TimestampedEvent{Sequence: 7}})
+	notifier.Publish(ad.Notification{Kind: ad.EventStored, Event: ad.TimestampedEvent{Sequence: 8}})
+
+	id, _ = readSSEMessage(t, reader)
+	assert.Equal(t, "8", id)
+}
+
+func TestSSEDropBeforeFirstSendStartsAtHead(t *testing.T) {
+	store := &fakeReader{
+		history: []ad.TimestampedEvent{
+			storedEvent(1, "agg1", "one"),
+			storedEvent(2, "agg1", "two"),
+			storedEvent(3, "agg2", "three"),
+		},
+		head:        2,
+		headCalled:  make(chan struct{}),
+		headRelease: make(chan struct{}),
+	}
+
+	notifier := ad.NewNotifier()
+	server := httptest.NewServer(NewSSEHandler(store, notifier))
+	defer server.Close()
+
+	responses := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.Get(server.URL)
+		if err != nil {
+			t.Error(err)
+			close(responses)
+			return
+		}
+		responses <- resp
+	}()
+
+	//Overflow the subscription while the handler reads the head, so notifications
+	//are dropped before anything has been sent
+	<-store.headCalled
+	for i := 0; i < sseBufferSize+10; i++ {
+		notifier.Publish(ad.Notification{Kind: ad.FeedCreated, FeedID: "feed-1"})
+	}
+	close(store.headRelease)
+
+	resp, ok := <-responses
+	if !ok {
+		return
+	}
+	defer resp.Body.Close()
+
+	id, _ := readSSEMessage(t, bufio.NewReader(resp.Body))
+	assert.Equal(t, "3", id)
+	assert.Equal(t, []int64{2}, store.sinceCalls)
+}
+
+func TestSSEBadLastEventID(t *testing.T) {
+	req := httptest.NewRequest("GET", "/events", nil)
+	req.Header.Set("Last-Event-ID", "yesterday")
+	rec := httptest.NewRecorder()
+	NewSSEHandler(&fakeReader{}, ad.NewNotifier()).ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,118 @@
+package esatompub
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+const (
+	sqlSelectFeedInfoColumns = `select f.feedid, f.event_time, f.previous,
+(select n.feedid from t_aefd_feed n where n.id = (select min(id) from t_aefd_feed where previous = f.feedid)),
+(select count(*) from t_aeae_atom_event e where e.feedid = f.feedid),
+(select min(e.event_time) from t_aeae_atom_event e where e.feedid = f.feedid),
+(select max(e.event_time) from t_aeae_atom_event e where e.feedid = f.feedid)
+from t_aefd_feed f`
+	sqlSelectFeedInfo = sqlSelectFeedInfoColumns + ` where f.feedid = :1`
+	sqlListFeeds      = sqlSelectFeedInfoColumns + ` order by f.id`
+)
+
+// FeedInfo summarizes an archive feed: when it was created, the events it holds
+// and its neighbours in the feed chain.
+type FeedInfo struct {
+	FeedID     string
+	Created    time.Time
+	EntryCount int
+	FirstEvent time.Time //Zero if the feed holds no events
+	LastEvent  time.Time //Zero if the feed holds no events
+	Previous   sql.NullString
+	Next       sql.NullString
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanFeedInfo(row rowScanner) (FeedInfo, error) {
+	var info FeedInfo
+	var first, last sql.NullTime
+
+	err := row.Scan(&info.FeedID, &info.Created, &info.Previous, &info.Next,
+		&info.EntryCount, &first, &last)
+	if err != nil {
+		return info, err
+	}
+
+	info.FirstEvent = first.Time
+	info.LastEvent = last.Time
+
+	return info, nil
+}
+
+// RetrieveFeedInfo returns the metadata for the given archive feed, or ErrFeedNotFound
+// if there is no such feed.
+func RetrieveFeedInfo(db *sql.DB, feedid string) (FeedInfo, error) {
+	return RetrieveFeedInfoContext(context.Background(), db, feedid)
+}
+
+// RetrieveFeedInfoContext is like RetrieveFeedInfo, but the query is bound to ctx.
+func RetrieveFeedInfoContext(ctx context.Context, db *sql.DB, feedid string) (FeedInfo, error) {
+	return newStore(db).RetrieveFeedInfoContext(ctx, feedid)
+}
+
+// RetrieveFeedInfoContext is like the package level RetrieveFeedInfo, but the query is
+// run through the store and bound to ctx.
+func (s *Store) RetrieveFeedInfoContext(ctx context.Context, feedid string) (info FeedInfo, err error) {
+	ctx, span := s.startSpan(ctx, "RetrieveFeedInfo", AttributeFeedID.String(feedid))
+	defer func() { endSpan(span, err) }()
+
+	info, err = scanFeedInfo(s.queryRow(ctx, sqlSelectFeedInfo, feedid))
+	if err == sql.ErrNoRows {
+		return info, ErrFeedNotFound
+	}
+
+	return info, err
+}
+
+// ListFeeds returns the metadata for the archive feeds, oldest first. Use WithOffset
+// and WithLimit to page through the feeds; other query options are ignored.
+func ListFeeds(db *sql.DB, opts ...QueryOption) ([]FeedInfo, error) {
+	return ListFeedsContext(context.Background(), db, opts...)
+}
+
+// ListFeedsContext is like ListFeeds, but the query is bound to ctx.
+func ListFeedsContext(ctx context.Context, db *sql.DB, opts ...QueryOption) ([]FeedInfo, error) {
+	return newStore(db).ListFeedsContext(ctx, opts...)
+}
+
+// ListFeedsContext is like the package level ListFeeds, but the query is run through
+// the store and bound to ctx.
+func (s *Store) ListFeedsContext(ctx context.Context, opts ...QueryOption) (feeds []FeedInfo, err error) {
+	ctx, span := s.startSpan(ctx, "ListFeeds")
+	defer func() { endSpan(span, err) }()
+
+	var args bindArgs
+	query := sqlListFeeds + args.pageClause(applyQueryOptions(opts))
+
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return feeds, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		info, err := scanFeedInfo(rows)
+		if err != nil {
+			return feeds, err
+		}
+
+		feeds = append(feeds, info)
+	}
+
+	if err = rows.Err(); err != nil {
+		return feeds, err
+	}
+
+	return feeds, nil
+}
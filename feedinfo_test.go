@@ -0,0 +1,109 @@
+package esatompub
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"testing"
+	"time"
+)
+
+var feedInfoColumns = []string{"feedid", "event_time", "previous", "next",
+	"count", "first", "last"}
+
+func TestRetrieveFeedInfo(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	created := time.Now()
+	first := created.Add(-time.Minute)
+	rows := sqlmock.NewRows(feedInfoColumns).
+		AddRow("feed-2", created, "feed-1", "feed-3", 100, first, created)
+	mock.ExpectQuery("select f.feedid").WithArgs("feed-2").WillReturnRows(rows)
+
+	info, err := RetrieveFeedInfo(db, "feed-2")
+	if assert.Nil(t, err) {
+		assert.Nil(t, mock.ExpectationsWereMet())
+		assert.Equal(t, "feed-2", info.FeedID)
+		assert.Equal(t, created, info.Created)
+		assert.Equal(t, 100, info.EntryCount)
+		assert.Equal(t, first, info.FirstEvent)
+		assert.Equal(t, created, info.LastEvent)
+		assert.Equal(t, "feed-1", info.Previous.String)
+		assert.Equal(t, "feed-3", info.Next.String)
+	}
+}
+
+func TestRetrieveFeedInfoEmptyFirstFeed(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	rows := sqlmock.NewRows(feedInfoColumns).
+		AddRow("feed-1", time.Now(), nil, nil, 0, nil, nil)
+	mock.ExpectQuery("select f.feedid").WithArgs("feed-1").WillReturnRows(rows)
+
+	info, err := RetrieveFeedInfo(db, "feed-1")
+	if assert.Nil(t, err) {
+		assert.False(t, info.Previous.Valid)
+		assert.False(t, info.Next.Valid)
+		assert.True(t, info.FirstEvent.IsZero())
+	}
+}
+
+func TestRetrieveFeedInfoNotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("select f.feedid").WithArgs("nope").WillReturnRows(sqlmock.NewRows(feedInfoColumns))
+
+	_, err = RetrieveFeedInfo(db, "nope")
+	assert.True(t, errors.Is(err, ErrFeedNotFound))
+}
+
+func TestListFeeds(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	ts := time.Now()
+	rows := sqlmock.NewRows(feedInfoColumns).
+		AddRow("feed-3", ts, "feed-2", "feed-4", 100, ts, ts).
+		AddRow("feed-4", ts, "feed-3", nil, 100, ts, ts)
+	mock.ExpectQuery(`order by f.id offset :1 rows fetch next :2 rows only`).
+		WithArgs(2, 2).WillReturnRows(rows)
+
+	feeds, err := ListFeeds(db, WithOffset(2), WithLimit(2))
+	if assert.Nil(t, err) {
+		assert.Nil(t, mock.ExpectationsWereMet())
+		if assert.Equal(t, 2, len(feeds)) {
+			assert.Equal(t, "feed-3", feeds[0].FeedID)
+			assert.False(t, feeds[1].Next.Valid)
+		}
+	}
+}
+
+func TestListFeedsError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("select f.feedid").WillReturnError(errors.New("boom"))
+
+	_, err = ListFeeds(db)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "boom", err.Error())
+	}
+}
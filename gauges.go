@@ -0,0 +1,149 @@
+package esatompub
+
+import (
+	"context"
+	"database/sql"
+	"github.com/xtracdev/goes"
+	"time"
+)
+
+// sqlGaugeStats measures the ages on the database clock, as seconds, so they don't
+// depend on the clock of the process taking the sample.
+const sqlGaugeStats = `select ` +
+	`(select count(*) from t_aeae_atom_event where feedid is null), ` +
+	`(select ` + sqlAgeSeconds + ` from (select min(event_time) t from t_aeae_atom_event where feedid is null)), ` +
+	`(select ` + sqlAgeSeconds + ` from (select max(event_time) t from t_aeae_atom_event)), ` +
+	`(select count(*) from t_aefd_feed), ` +
+	`(select ` + sqlAgeSeconds + ` from (select max(event_time) t from t_aefd_feed)) ` +
+	`from dual`
+
+// sqlAgeSeconds is the time since t in seconds, null if t is null.
+const sqlAgeSeconds = `extract(day from (systimestamp - t)) * 86400 + ` +
+	`extract(hour from (systimestamp - t)) * 3600 + ` +
+	`extract(minute from (systimestamp - t)) * 60 + ` +
+	`extract(second from (systimestamp - t))`
+
+// Gauge keys. Ages and lags are in seconds.
+var (
+	gaugeRecentCount     = []string{"es-atom-data", "recent", "count"}
+	gaugeRecentOldestAge = []string{"es-atom-data", "recent", "oldest-age-seconds"}
+	gaugeNewestEventAge  = []string{"es-atom-data", "events", "newest-age-seconds"}
+	gaugeFeedCount       = []string{"es-atom-data", "feeds", "count"}
+	gaugeNewestFeedAge   = []string{"es-atom-data", "feeds", "newest-age-seconds"}
+	gaugePublishLag      = []string{"es-atom-data", "publish", "lag-seconds"}
+)
+
+// Gauges is a sample of the state of the atom data tables. Ages are measured against
+// the database clock, and are zero when there is nothing to measure.
+type Gauges struct {
+	// RecentCount is the number of events on the recent page, yet to be assigned a feed.
+	RecentCount int
+
+	// OldestRecentAge is the age of the oldest event on the recent page. It grows
+	// without bound if rollovers stop.
+	OldestRecentAge time.Duration
+
+	// NewestEventAge is the time since an event was last stored.
+	NewestEventAge time.Duration
+
+	// FeedCount is the number of archive feeds.
+	FeedCount int
+
+	// NewestFeedAge is the time since the newest archive feed was created.
+	NewestFeedAge time.Duration
+}
+
+// WithEventTime sets a function returning the time an event was created in the event
+// store, for example from a timestamp in its payload. goes.Event carries no time of
+// its own. When set, the lag between the event time and the time the event is
+// stored is emitted as the es-atom-data.publish.lag-seconds gauge for each event.
+func WithEventTime(eventTime func(event *goes.Event) (time.Time, bool)) StoreOption {
+	return func(s *Store) {
+		s.eventTime = eventTime
+	}
+}
+
+// SampleGauges samples the gauges for the atom data tables in db, emitting them to
+// the armon/go-metrics global.
+func SampleGauges(db *sql.DB) (Gauges, error) {
+	return SampleGaugesContext(context.Background(), db)
+}
+
+// SampleGaugesContext is like SampleGauges, but the query is bound to ctx.
+func SampleGaugesContext(ctx context.Context, db *sql.DB) (Gauges, error) {
+	return newStore(db).SampleGaugesContext(ctx)
+}
+
+// SampleGaugesContext samples the gauges from the primary database and emits them to
+// the store's metrics:
+//
+//	es-atom-data.recent.count               events on the recent page
+//	es-atom-data.recent.oldest-age-seconds  age of the oldest event on the recent page
+//	es-atom-data.events.newest-age-seconds  time since an event was last stored
+//	es-atom-data.feeds.count                archive feeds
+//	es-atom-data.feeds.newest-age-seconds   time since the newest archive feed was created
+//
+// A recent page older than the expected time to fill it points to a stuck rollover.
+func (s *Store) SampleGaugesContext(ctx context.Context) (gauges Gauges, err error) {
+	ctx, span := s.startSpan(ctx, "SampleGauges")
+	defer func() { endSpan(span, err) }()
+
+	var oldestRecent, newestEvent, newestFeed sql.NullFloat64
+	err = s.queryRowOn(ctx, s.db, s.stmts, sqlGaugeStats).Scan(
+		&gauges.RecentCount, &oldestRecent, &newestEvent, &gauges.FeedCount, &newestFeed)
+	if err != nil {
+		return gauges, err
+	}
+
+	gauges.OldestRecentAge = age(oldestRecent)
+	gauges.NewestEventAge = age(newestEvent)
+	gauges.NewestFeedAge = age(newestFeed)
+
+	s.metrics.SetGauge(gaugeRecentCount, float32(gauges.RecentCount))
+	s.metrics.SetGauge(gaugeRecentOldestAge, float32(gauges.OldestRecentAge.Seconds()))
+	s.metrics.SetGauge(gaugeNewestEventAge, float32(gauges.NewestEventAge.Seconds()))
+	s.metrics.SetGauge(gaugeFeedCount, float32(gauges.FeedCount))
+	s.metrics.SetGauge(gaugeNewestFeedAge, float32(gauges.NewestFeedAge.Seconds()))
+
+	return gauges, nil
+}
+
+// age converts an age in seconds from sqlAgeSeconds to a duration.
+func age(seconds sql.NullFloat64) time.Duration {
+	if !seconds.Valid {
+		return 0
+	}
+	return time.Duration(seconds.Float64 * float64(time.Second))
+}
+
+// RunGaugeSampler samples the gauges every interval until ctx is done. Failed samples
+// are logged and the gauges left at their last values. Run it in its own goroutine.
+func (s *Store) RunGaugeSampler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.SampleGaugesContext(ctx); err != nil && ctx.Err() == nil {
+			s.logger.Warn("Unable to sample gauges", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// writePublishLag emits the lag between the event time and now, if the store can
+// tell the event time.
+func (s *Store) writePublishLag(event *goes.Event) {
+	if s.eventTime == nil {
+		return
+	}
+
+	if eventTime, ok := s.eventTime(event); ok {
+		lag := time.Now().Sub(eventTime)
+		go s.metrics.SetGauge(gaugePublishLag, float32(lag.Seconds()))
+	}
+}
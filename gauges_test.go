@@ -0,0 +1,133 @@
+package esatompub
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/goes"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var gaugeColumns = []string{"recent", "oldest_recent", "newest_event", "feeds", "newest_feed"}
+
+type gaugeMetrics struct {
+	recordingMetrics
+	values map[string]float32
+}
+
+func newGaugeMetrics() *gaugeMetrics {
+	return &gaugeMetrics{recordingMetrics: recordingMetrics{keys: make(map[string]int)}, values: make(map[string]float32)}
+}
+
+func (m *gaugeMetrics) SetGauge(key []string, val float32) {
+	m.mu.Lock()
+	m.values[strings.Join(key, ".")] = val
+	m.mu.Unlock()
+	m.record(key)
+}
+
+func (m *gaugeMetrics) value(key string) float32 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.values[key]
+}
+
+func TestSampleGauges(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`systimestamp - t.*from dual`).WillReturnRows(sqlmock.NewRows(gaugeColumns).
+		AddRow(7, 3600.5, 60, 12, 7200))
+
+	m := newGaugeMetrics()
+	gauges, err := newStore(db, WithMetrics(m)).SampleGaugesContext(context.Background())
+	if assert.Nil(t, err) {
+		assert.Equal(t, 7, gauges.RecentCount)
+		assert.Equal(t, 12, gauges.FeedCount)
+		assert.Equal(t, time.Hour+500*time.Millisecond, gauges.OldestRecentAge)
+		assert.Equal(t, time.Minute, gauges.NewestEventAge)
+		assert.Equal(t, 2*time.Hour, gauges.NewestFeedAge)
+
+		assert.Equal(t, float32(7), m.value("es-atom-data.recent.count"))
+		assert.Equal(t, float32(12), m.value("es-atom-data.feeds.count"))
+		assert.Equal(t, float32(3600.5), m.value("es-atom-data.recent.oldest-age-seconds"))
+		assert.Equal(t, float32(7200), m.value("es-atom-data.feeds.newest-age-seconds"))
+		assert.Equal(t, 1, m.count("es-atom-data.events.newest-age-seconds"))
+	}
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestSampleGaugesEmptyTables(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("from dual").WillReturnRows(sqlmock.NewRows(gaugeColumns).AddRow(0, nil, nil, 0, nil))
+
+	gauges, err := newStore(db, WithMetrics(newGaugeMetrics())).SampleGaugesContext(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, Gauges{}, gauges)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestRunGaugeSamplerStopsWithContext(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("from dual").WillReturnRows(sqlmock.NewRows(gaugeColumns).AddRow(1, 0, 0, 0, nil))
+
+	m := newGaugeMetrics()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		newStore(db, WithMetrics(m)).RunGaugeSampler(ctx, time.Hour)
+	}()
+
+	assert.Equal(t, 1, waitForCount(&m.recordingMetrics, "es-atom-data.recent.count", 1))
+	cancel()
+	wg.Wait()
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestProcessEventEmitsPublishLag(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("lock table t_aefd_feed").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("select feedid from t_aefd_feed").WillReturnRows(sqlmock.NewRows([]string{"feedid"}).AddRow("f1"))
+	mock.ExpectExec("insert into t_aeae_atom_event").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("select count").WillReturnRows(sqlmock.NewRows([]string{"count(*)", "max(id)"}).AddRow(1, 42))
+	mock.ExpectCommit()
+
+	created := time.Now().Add(-30 * time.Second)
+	eventTime := func(event *goes.Event) (time.Time, bool) {
+		return created, true
+	}
+
+	m := newGaugeMetrics()
+	store := newStore(db, WithMetrics(m), WithFeedThreshold(2), WithEventTime(eventTime))
+	err = store.ProcessEventContext(context.Background(), &goes.Event{Source: "agg1", Version: 1, TypeCode: "foo"})
+	if assert.Nil(t, err) {
+		assert.Equal(t, 1, waitForCount(&m.recordingMetrics, "es-atom-data.publish.lag-seconds", 1))
+		assert.True(t, m.value("es-atom-data.publish.lag-seconds") >= 30)
+	}
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
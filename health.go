@@ -0,0 +1,134 @@
+package esatompub
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const (
+	sqlRecentStats    = `select n, t, ` + sqlAgeSeconds + ` from (select count(*) n, min(event_time) t from t_aeae_atom_event where feedid is null)`
+	sqlChainAnomalies = `select ` +
+		`(select count(*) from t_aefd_feed where previous is null), ` +
+		`(select count(*) from (select previous from t_aefd_feed where previous is not null group by previous having count(*) > 1)), ` +
+		`(select count(*) from t_aefd_feed f where f.previous is not null and not exists (select 1 from t_aefd_feed p where p.feedid = f.previous)) ` +
+		`from dual`
+)
+
+// HealthStatus summarises a Health report.
+type HealthStatus string
+
+const (
+	// HealthOK means the database is reachable and no anomalies were found.
+	HealthOK HealthStatus = "ok"
+
+	// HealthDegraded means the database is reachable but the feed data has anomalies.
+	HealthDegraded HealthStatus = "degraded"
+
+	// HealthDown means the database could not be queried.
+	HealthDown HealthStatus = "down"
+)
+
+// Health reports the state of the atom data store.
+type Health struct {
+	Status        HealthStatus `json:"status"`
+	CheckedAt     time.Time    `json:"checked_at"`
+	DatabaseError string       `json:"database_error,omitempty"`
+
+	// RecentCount is the number of events on the recent page, which are yet to be
+	// assigned a feed. The page is archived when it reaches FeedThreshold events.
+	RecentCount   int `json:"recent_count"`
+	FeedThreshold int `json:"feed_threshold"`
+
+	// OldestUnassigned is the event time of the oldest event on the recent page, if any.
+	// Its age is measured against the database clock.
+	OldestUnassigned    *time.Time `json:"oldest_unassigned,omitempty"`
+	OldestUnassignedAge float64    `json:"oldest_unassigned_age_seconds"`
+
+	// Anomalies describes problems found in the recent page and the feed chain.
+	Anomalies []string `json:"anomalies,omitempty"`
+}
+
+// HealthChecker is implemented by Store.
+type HealthChecker interface {
+	HealthContext(ctx context.Context) Health
+}
+
+// CheckHealth reports the state of the atom data store in db.
+func CheckHealth(db *sql.DB) Health {
+	return CheckHealthContext(context.Background(), db)
+}
+
+// CheckHealthContext is like CheckHealth, but the queries are bound to ctx.
+func CheckHealthContext(ctx context.Context, db *sql.DB) Health {
+	return newStore(db).HealthContext(ctx)
+}
+
+// HealthContext checks the primary database is reachable, reports the size and age
+// of the recent page, and looks for feed chain anomalies: more than one feed
+// starting the chain, forks where feeds share a previous feed, and previous links to
+// feeds that don't exist. The checks always go to the primary, not a reader handle.
+func (s *Store) HealthContext(ctx context.Context) Health {
+	health := Health{
+		Status:        HealthOK,
+		CheckedAt:     time.Now(),
+		FeedThreshold: s.threshold(),
+	}
+
+	if err := s.checkHealth(ctx, &health); err != nil {
+		health.Status = HealthDown
+		health.DatabaseError = err.Error()
+		return health
+	}
+
+	if len(health.Anomalies) > 0 {
+		health.Status = HealthDegraded
+	}
+
+	return health
+}
+
+func (s *Store) checkHealth(ctx context.Context, health *Health) error {
+	if err := s.db.PingContext(ctx); err != nil {
+		return err
+	}
+
+	var oldest sql.NullTime
+	var oldestAge sql.NullFloat64
+	err := s.queryRowOn(ctx, s.db, s.stmts, sqlRecentStats).Scan(&health.RecentCount, &oldest, &oldestAge)
+	if err != nil {
+		return err
+	}
+
+	if oldest.Valid {
+		health.OldestUnassigned = &oldest.Time
+		health.OldestUnassignedAge = oldestAge.Float64
+	}
+
+	if health.RecentCount > health.FeedThreshold {
+		health.Anomalies = append(health.Anomalies,
+			fmt.Sprintf("recent page holds %d events, more than the feed threshold of %d", health.RecentCount, health.FeedThreshold))
+	}
+
+	var heads, forks, dangling int
+	err = s.queryRowOn(ctx, s.db, s.stmts, sqlChainAnomalies).Scan(&heads, &forks, &dangling)
+	if err != nil {
+		return err
+	}
+
+	if heads > 1 {
+		health.Anomalies = append(health.Anomalies,
+			fmt.Sprintf("%d feeds start a feed chain, expected one", heads))
+	}
+	if forks > 0 {
+		health.Anomalies = append(health.Anomalies,
+			fmt.Sprintf("%d feeds are the previous feed of more than one feed", forks))
+	}
+	if dangling > 0 {
+		health.Anomalies = append(health.Anomalies,
+			fmt.Sprintf("%d feeds link to a previous feed that does not exist", dangling))
+	}
+
+	return nil
+}
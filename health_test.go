@@ -0,0 +1,85 @@
+package esatompub
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"testing"
+	"time"
+)
+
+var recentStatsColumns = []string{"n", "t", "age"}
+
+func TestHealthOK(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	//The age comes from the database, however far the local clock is from the event time
+	oldest := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(`select n, t, .*systimestamp - t.* from \(select count\(\*\) n, min\(event_time\) t`).
+		WillReturnRows(sqlmock.NewRows(recentStatsColumns).AddRow(3, oldest, 61.5))
+	mock.ExpectQuery("from dual").
+		WillReturnRows(sqlmock.NewRows([]string{"heads", "forks", "dangling"}).AddRow(1, 0, 0))
+
+	health := newStore(db, WithFeedThreshold(10)).HealthContext(context.Background())
+	assert.Equal(t, HealthOK, health.Status)
+	assert.Equal(t, 3, health.RecentCount)
+	assert.Equal(t, 10, health.FeedThreshold)
+	if assert.NotNil(t, health.OldestUnassigned) {
+		assert.Equal(t, oldest, *health.OldestUnassigned)
+		assert.Equal(t, 61.5, health.OldestUnassignedAge)
+	}
+	assert.Empty(t, health.Anomalies)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestHealthDegraded(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`min\(event_time\)`).
+		WillReturnRows(sqlmock.NewRows(recentStatsColumns).AddRow(12, time.Now(), 0))
+	mock.ExpectQuery("from dual").
+		WillReturnRows(sqlmock.NewRows([]string{"heads", "forks", "dangling"}).AddRow(2, 1, 1))
+
+	health := newStore(db, WithFeedThreshold(10)).HealthContext(context.Background())
+	assert.Equal(t, HealthDegraded, health.Status)
+	assert.Equal(t, 4, len(health.Anomalies))
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestHealthEmptyRecentPage(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`min\(event_time\)`).
+		WillReturnRows(sqlmock.NewRows(recentStatsColumns).AddRow(0, nil, nil))
+	mock.ExpectQuery("from dual").
+		WillReturnRows(sqlmock.NewRows([]string{"heads", "forks", "dangling"}).AddRow(0, 0, 0))
+
+	health := CheckHealth(db)
+	assert.Equal(t, HealthOK, health.Status)
+	assert.Nil(t, health.OldestUnassigned)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestHealthDown(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	db.Close()
+
+	health := CheckHealth(db)
+	assert.Equal(t, HealthDown, health.Status)
+	assert.NotEqual(t, "", health.DatabaseError)
+}
@@ -0,0 +1,74 @@
+// Package envconfig reads the Oracle connection settings shared by the integration
+// tests and the esatom command from the environment.
+package envconfig
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Config holds the database connection settings.
+type Config struct {
+	DBUser     string
+	DBPassword string
+	DBHost     string
+	DBPort     string
+	DBSvc      string
+}
+
+// MaskedConnectString returns the oci8 connect string with the password masked, for logging.
+func (ec *Config) MaskedConnectString() string {
+	return fmt.Sprintf("%s/%s@//%s:%s/%s",
+		ec.DBUser, "XXX", ec.DBHost, ec.DBPort, ec.DBSvc)
+}
+
+// ConnectString returns the oci8 connect string.
+func (ec *Config) ConnectString() string {
+	return fmt.Sprintf("%s/%s@//%s:%s/%s",
+		ec.DBUser, ec.DBPassword, ec.DBHost, ec.DBPort, ec.DBSvc)
+}
+
+// NewEnvConfig reads the DB_USER, DB_PASSWORD, DB_HOST, DB_PORT and DB_SVC environment
+// variables, returning an error listing every one that is missing.
+func NewEnvConfig() (*Config, error) {
+	var configErrors []string
+
+	user := os.Getenv("DB_USER")
+	if user == "" {
+		configErrors = append(configErrors, "Configuration missing DB_USER env variable")
+	}
+
+	password := os.Getenv("DB_PASSWORD")
+	if password == "" {
+		configErrors = append(configErrors, "Configuration missing DB_PASSWORD env variable")
+	}
+
+	dbhost := os.Getenv("DB_HOST")
+	if dbhost == "" {
+		configErrors = append(configErrors, "Configuration missing DB_HOST env variable")
+	}
+
+	dbPort := os.Getenv("DB_PORT")
+	if dbPort == "" {
+		configErrors = append(configErrors, "Configuration missing DB_PORT env variable")
+	}
+
+	dbSvc := os.Getenv("DB_SVC")
+	if dbSvc == "" {
+		configErrors = append(configErrors, "Configuration missing DB_SVC env variable")
+	}
+
+	if len(configErrors) != 0 {
+		return nil, errors.New(strings.Join(configErrors, "\n"))
+	}
+
+	return &Config{
+		DBUser:     user,
+		DBPassword: password,
+		DBHost:     dbhost,
+		DBPort:     dbPort,
+		DBSvc:      dbSvc,
+	}, nil
+}
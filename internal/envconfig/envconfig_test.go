@@ -0,0 +1,34 @@
+package envconfig
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"testing"
+)
+
+var envVars = []string{"DB_USER", "DB_PASSWORD", "DB_HOST", "DB_PORT", "DB_SVC"}
+
+func TestNewEnvConfig(t *testing.T) {
+	for _, name := range envVars {
+		os.Setenv(name, strings.ToLower(name))
+		defer os.Unsetenv(name)
+	}
+
+	config, err := NewEnvConfig()
+	if assert.Nil(t, err) {
+		assert.Equal(t, "db_user/db_password@//db_host:db_port/db_svc", config.ConnectString())
+		assert.Equal(t, "db_user/XXX@//db_host:db_port/db_svc", config.MaskedConnectString())
+	}
+}
+
+func TestNewEnvConfigMissing(t *testing.T) {
+	for _, name := range envVars {
+		os.Unsetenv(name)
+	}
+
+	_, err := NewEnvConfig()
+	if assert.NotNil(t, err) {
+		assert.Equal(t, len(envVars), len(strings.Split(err.Error(), "\n")))
+	}
+}
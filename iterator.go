@@ -0,0 +1,145 @@
+package esatompub
+
+import (
+	"context"
+	"database/sql"
+	"github.com/xtracdev/goes"
+	"time"
+)
+
+const (
+	sqlSelectHistory = `select id, feedid, event_time, aggregate_id, version, typecode, payload from t_aeae_atom_event order by id`
+)
+
+// EventIterator provides cursor style access to the events selected by a feed
+// query, reading one row at a time so callers can process large result sets in
+// constant memory. Callers must Close the iterator when done with it.
+type EventIterator struct {
+	rows  *sql.Rows
+	event TimestampedEvent
+	err   error
+}
+
+// IterateRecent returns an iterator over the recent events, newest first.
+func IterateRecent(db *sql.DB) (*EventIterator, error) {
+	return IterateRecentContext(context.Background(), db)
+}
+
+// IterateRecentContext is like IterateRecent, but the query is bound to ctx.
+func IterateRecentContext(ctx context.Context, db *sql.DB) (*EventIterator, error) {
+	return newStore(db).IterateRecentContext(ctx)
+}
+
+// IterateRecentContext returns an iterator over the recent events, newest first.
+func (s *Store) IterateRecentContext(ctx context.Context) (*EventIterator, error) {
+	return s.iterateEvents(ctx, sqlSelectRecent)
+}
+
+// IterateArchive returns an iterator over the events in the given archive feed, newest first.
+func IterateArchive(db *sql.DB, feedid string) (*EventIterator, error) {
+	return IterateArchiveContext(context.Background(), db, feedid)
+}
+
+// IterateArchiveContext is like IterateArchive, but the query is bound to ctx.
+func IterateArchiveContext(ctx context.Context, db *sql.DB, feedid string) (*EventIterator, error) {
+	return newStore(db).IterateArchiveContext(ctx, feedid)
+}
+
+// IterateArchiveContext returns an iterator over the events in the given archive feed, newest first.
+func (s *Store) IterateArchiveContext(ctx context.Context, feedid string) (*EventIterator, error) {
+	return s.iterateEvents(ctx, sqlSelectForFeed, feedid)
+}
+
+// IterateHistory returns an iterator over every stored event, archived and recent,
+// in the order the events were written.
+func IterateHistory(db *sql.DB) (*EventIterator, error) {
+	return IterateHistoryContext(context.Background(), db)
+}
+
+// IterateHistoryContext is like IterateHistory, but the query is bound to ctx.
+func IterateHistoryContext(ctx context.Context, db *sql.DB) (*EventIterator, error) {
+	return newStore(db).IterateHistoryContext(ctx)
+}
+
+// IterateHistoryContext returns an iterator over every stored event, archived and recent,
+// in the order the events were written.
+func (s *Store) IterateHistoryContext(ctx context.Context) (*EventIterator, error) {
+	return s.iterateEvents(ctx, sqlSelectHistory)
+}
+
+func (s *Store) iterateEvents(ctx context.Context, query string, args ...interface{}) (*EventIterator, error) {
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EventIterator{rows: rows}, nil
+}
+
+// Next advances the iterator to the next event, returning false when there are no
+// more events or an error occurred. Err distinguishes the two cases.
+func (it *EventIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+
+	if !it.rows.Next() {
+		it.err = it.rows.Err()
+		return false
+	}
+
+	var sequence int64
+	var feedid sql.NullString
+	var eventTime time.Time
+	var aggregateId, typecode string
+	var version int
+	var payload []byte
+
+	if err := it.rows.Scan(&sequence, &feedid, &eventTime, &aggregateId, &version, &typecode, &payload); err != nil {
+		it.err = err
+		return false
+	}
+
+	it.event = TimestampedEvent{
+		Event: goes.Event{
+			Source:   aggregateId,
+			Version:  version,
+			Payload:  payload,
+			TypeCode: typecode,
+		},
+		Timestamp: eventTime,
+		Sequence:  sequence,
+		FeedID:    feedid.String,
+	}
+
+	return true
+}
+
+// Event returns the event the iterator is currently positioned on.
+func (it *EventIterator) Event() TimestampedEvent {
+	return it.event
+}
+
+// Err returns the error, if any, that terminated the iteration.
+func (it *EventIterator) Err() error {
+	return it.err
+}
+
+// Close releases the database resources held by the iterator.
+func (it *EventIterator) Close() error {
+	return it.rows.Close()
+}
+
+// Walk invokes fn for each remaining event, stopping at the first error returned by
+// fn or by the underlying query. The iterator is closed when Walk returns.
+func (it *EventIterator) Walk(fn func(TimestampedEvent) error) error {
+	defer it.Close()
+
+	for it.Next() {
+		if err := fn(it.Event()); err != nil {
+			return err
+		}
+	}
+
+	return it.Err()
+}
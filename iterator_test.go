@@ -0,0 +1,110 @@
+package esatompub
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"testing"
+	"time"
+)
+
+func TestIterateRecent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	ts := time.Now()
+	rows := sqlmock.NewRows([]string{"id", "feedid", "event_time", "aggregate_id",
+		"version", "typecode", "payload"},
+	).AddRow(int64(1), nil, ts, "1x2x333", 3, "foo", []byte("yeah ok")).
+		AddRow(int64(2), nil, ts, "1x2x333", 2, "bar", []byte("ok"))
+	mock.ExpectQuery("select").WillReturnRows(rows)
+
+	it, err := IterateRecent(db)
+	if assert.Nil(t, err) {
+		defer it.Close()
+
+		var versions []int
+		for it.Next() {
+			versions = append(versions, it.Event().Version)
+		}
+
+		assert.Nil(t, it.Err())
+		assert.Equal(t, []int{3, 2}, versions)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	}
+}
+
+func TestIterateArchiveQueryError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("select").WithArgs("foo").WillReturnError(errors.New("boom"))
+
+	_, err = IterateArchive(db, "foo")
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "boom", err.Error())
+	}
+}
+
+func TestIterateHistoryWalk(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	ts := time.Now()
+	rows := sqlmock.NewRows([]string{"id", "feedid", "event_time", "aggregate_id",
+		"version", "typecode", "payload"},
+	).AddRow(int64(3), nil, ts, "agg1", 1, "foo", []byte("one")).
+		AddRow(int64(4), nil, ts, "agg2", 1, "foo", []byte("two"))
+	mock.ExpectQuery("select .* order by id").WillReturnRows(rows)
+
+	it, err := IterateHistory(db)
+	if assert.Nil(t, err) {
+		var sources []string
+		err = it.Walk(func(event TimestampedEvent) error {
+			sources = append(sources, event.Source)
+			return nil
+		})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"agg1", "agg2"}, sources)
+		assert.Nil(t, mock.ExpectationsWereMet())
+	}
+}
+
+func TestWalkStopsOnCallbackError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	ts := time.Now()
+	rows := sqlmock.NewRows([]string{"id", "feedid", "event_time", "aggregate_id",
+		"version", "typecode", "payload"},
+	).AddRow(int64(5), nil, ts, "agg1", 1, "foo", []byte("one")).
+		AddRow(int64(6), nil, ts, "agg2", 1, "foo", []byte("two"))
+	mock.ExpectQuery("select").WillReturnRows(rows)
+
+	it, err := IterateHistory(db)
+	if assert.Nil(t, err) {
+		var calls int
+		err = it.Walk(func(event TimestampedEvent) error {
+			calls++
+			return errors.New("stop")
+		})
+
+		if assert.NotNil(t, err) {
+			assert.Equal(t, "stop", err.Error())
+		}
+		assert.Equal(t, 1, calls)
+	}
+}
@@ -0,0 +1,108 @@
+package esatompub
+
+import (
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"sync/atomic"
+)
+
+// Logger receives the log messages written by the store and the processors. Fields
+// are alternating keys and values, as with log/slog, so a *slog.Logger satisfies
+// this interface. Use NewLogrusLogger to log through a logrus logger.
+type Logger interface {
+	Debug(msg string, fields ...interface{})
+	Info(msg string, fields ...interface{})
+	Warn(msg string, fields ...interface{})
+	Error(msg string, fields ...interface{})
+}
+
+// Log field keys.
+const (
+	LogFieldFeedID      = "feed_id"
+	LogFieldPrevious    = "previous_feed_id"
+	LogFieldAggregateID = "aggregate_id"
+	LogFieldVersion     = "version"
+	LogFieldSQL         = "sql"
+)
+
+// WithLogger sets the logger the store writes to. By default the standard logrus
+// logger is used.
+func WithLogger(l Logger) StoreOption {
+	return func(s *Store) {
+		s.logger = l
+	}
+}
+
+type logrusLogger struct {
+	logger logrus.FieldLogger
+}
+
+// NewLogrusLogger returns a Logger that writes to l, such as logrus.StandardLogger()
+// or an entry holding fields common to every message.
+func NewLogrusLogger(l logrus.FieldLogger) Logger {
+	return logrusLogger{logger: l}
+}
+
+var defaultLogger Logger = NewLogrusLogger(logrus.StandardLogger())
+
+// DefaultLogger returns the logger used when none is configured, which writes to the
+// standard logrus logger.
+func DefaultLogger() Logger {
+	return defaultLogger
+}
+
+func (l logrusLogger) Debug(msg string, fields ...interface{}) {
+	if l.enabled(logrus.DebugLevel) {
+		l.logger.WithFields(logrusFields(fields)).Debug(msg)
+	}
+}
+
+func (l logrusLogger) Info(msg string, fields ...interface{}) {
+	if l.enabled(logrus.InfoLevel) {
+		l.logger.WithFields(logrusFields(fields)).Info(msg)
+	}
+}
+
+func (l logrusLogger) Warn(msg string, fields ...interface{}) {
+	if l.enabled(logrus.WarnLevel) {
+		l.logger.WithFields(logrusFields(fields)).Warn(msg)
+	}
+}
+
+func (l logrusLogger) Error(msg string, fields ...interface{}) {
+	if l.enabled(logrus.ErrorLevel) {
+		l.logger.WithFields(logrusFields(fields)).Error(msg)
+	}
+}
+
+// enabled reports whether the underlying logger writes messages at level, so the
+// fields aren't built for messages that would be discarded. Loggers of other types
+// are assumed to write every level.
+func (l logrusLogger) enabled(level logrus.Level) bool {
+	var logger *logrus.Logger
+	switch fl := l.logger.(type) {
+	case *logrus.Logger:
+		logger = fl
+	case *logrus.Entry:
+		logger = fl.Logger
+	}
+	if logger == nil {
+		return true
+	}
+	//Read the level as logrus does, since SetLevel may be called concurrently
+	return logrus.Level(atomic.LoadUint32((*uint32)(&logger.Level))) >= level
+}
+
+// logrusFields pairs up keys and values. As with log/slog, a key without a value is
+// logged under !BADKEY.
+func logrusFields(fields []interface{}) logrus.Fields {
+	f := make(logrus.Fields, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		if i+1 == len(fields) {
+			f["!BADKEY"] = fields[i]
+			break
+		}
+		f[fmt.Sprint(fields[i])] = fields[i+1]
+	}
+	return f
+}
@@ -0,0 +1,86 @@
+package esatompub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"log/slog"
+	"testing"
+)
+
+var _ Logger = (*slog.Logger)(nil)
+
+func TestSlogLoggerReceivesStructuredFields(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	expectRolloverQueries(mock, 3)
+	mock.ExpectExec("update t_aeae_atom_event set feedid").WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec("insert into t_aefd_feed").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	feedid, err := newStore(db, WithLogger(logger)).ForceRolloverContext(context.Background())
+	assert.Nil(t, err)
+
+	records := make(map[string]map[string]interface{})
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var record map[string]interface{}
+		if assert.Nil(t, dec.Decode(&record)) {
+			records[record["msg"].(string)] = record
+		}
+	}
+
+	if assert.Contains(t, records, "Creating feed") {
+		assert.Equal(t, feedid, records["Creating feed"][LogFieldFeedID])
+		assert.Equal(t, "f1", records["Creating feed"][LogFieldPrevious])
+	}
+	if assert.Contains(t, records, "Statement executed") {
+		assert.NotEmpty(t, records["Statement executed"][LogFieldSQL])
+	}
+}
+
+func TestLogrusLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := logrus.New()
+	l.Out = &buf
+	l.Formatter = &logrus.JSONFormatter{}
+
+	NewLogrusLogger(l).Info("Creating feed", LogFieldFeedID, "f2", LogFieldVersion, 3, "dangling")
+
+	var record map[string]interface{}
+	if assert.Nil(t, json.Unmarshal(buf.Bytes(), &record)) {
+		assert.Equal(t, "Creating feed", record["msg"])
+		assert.Equal(t, "f2", record[LogFieldFeedID])
+		assert.Equal(t, float64(3), record[LogFieldVersion])
+		assert.Equal(t, "dangling", record["!BADKEY"])
+	}
+}
+
+func TestLogrusLoggerLevel(t *testing.T) {
+	var buf bytes.Buffer
+	l := logrus.New()
+	l.Out = &buf
+	l.Level = logrus.WarnLevel
+
+	for _, logger := range []Logger{NewLogrusLogger(l), NewLogrusLogger(l.WithField("component", "test"))} {
+		assert.False(t, logger.(logrusLogger).enabled(logrus.InfoLevel))
+		assert.True(t, logger.(logrusLogger).enabled(logrus.WarnLevel))
+
+		logger.Debug("Statement executed", LogFieldSQL, "select 1 from dual")
+		logger.Info("Creating feed", LogFieldFeedID, "f2")
+	}
+	assert.Equal(t, 0, buf.Len())
+
+	NewLogrusLogger(l).Warn("Reader is behind the primary, using primary", "lag", 2)
+	assert.Contains(t, buf.String(), "Reader is behind")
+}
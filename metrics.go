@@ -0,0 +1,147 @@
+package esatompub
+
+import (
+	"github.com/armon/go-metrics"
+	"github.com/armon/go-metrics/datadog"
+	"sync"
+	"time"
+)
+
+// Metrics receives the telemetry emitted by the store, the processors and the archive
+// cache. Keys are prefixed with es-atom-data; samples are durations in milliseconds.
+// A *metrics.Metrics from github.com/armon/go-metrics satisfies this interface.
+type Metrics interface {
+	SetGauge(key []string, val float32)
+	IncrCounter(key []string, val float32)
+	AddSample(key []string, val float32)
+}
+
+// Label is a metric label, sent as a tag by DogStatsD sinks.
+type Label = metrics.Label
+
+// LabeledMetrics is implemented by metrics that can label counters and samples, such
+// as *metrics.Metrics and PrometheusMetrics. The store emits the per typecode and per
+// feed throughput metrics with labels when its metrics implement this interface.
+type LabeledMetrics interface {
+	Metrics
+	IncrCounterWithLabels(key []string, val float32, labels []Label)
+	AddSampleWithLabels(key []string, val float32, labels []Label)
+}
+
+// globalMetrics forwards to the armon/go-metrics global, which configureStatsD sets up
+// for the processors created by NewESAtomPubProcessor and NewESAtomPubProcessorContext.
+type globalMetrics struct{}
+
+func (globalMetrics) SetGauge(key []string, val float32) {
+	metrics.SetGauge(key, val)
+}
+
+func (globalMetrics) IncrCounter(key []string, val float32) {
+	metrics.IncrCounter(key, val)
+}
+
+func (globalMetrics) AddSample(key []string, val float32) {
+	metrics.AddSample(key, val)
+}
+
+func (globalMetrics) IncrCounterWithLabels(key []string, val float32, labels []Label) {
+	metrics.IncrCounterWithLabels(key, val, labels)
+}
+
+func (globalMetrics) AddSampleWithLabels(key []string, val float32, labels []Label) {
+	metrics.AddSampleWithLabels(key, val, labels)
+}
+
+var defaultMetrics Metrics = globalMetrics{}
+
+// NewStatsdMetrics returns metrics sent to the statsd server at endpoint.
+func NewStatsdMetrics(endpoint string) (Metrics, error) {
+	sink, err := metrics.NewStatsdSink(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	return metrics.New(metrics.DefaultConfig(endpoint), sink)
+}
+
+// NewDogStatsdMetrics returns metrics sent to the DogStatsD agent at endpoint, with
+// labels sent as tags.
+func NewDogStatsdMetrics(endpoint string, hostname string) (Metrics, error) {
+	sink, err := datadog.NewDogStatsdSink(endpoint, hostname)
+	if err != nil {
+		return nil, err
+	}
+
+	return metrics.New(metrics.DefaultConfig(""), sink)
+}
+
+// NewInmemMetrics returns metrics accumulated in memory, which are dumped to stderr
+// when the process receives a USR1 signal.
+func NewInmemMetrics() (Metrics, error) {
+	inm := metrics.NewInmemSink(10*time.Second, 5*time.Minute)
+	metrics.DefaultInmemSignal(inm)
+	return metrics.New(metrics.DefaultConfig("xavi"), inm)
+}
+
+func durationMillis(start time.Time) float32 {
+	return float32(time.Now().Sub(start).Nanoseconds()) / 1000.0 / 1000.0
+}
+
+const (
+	defaultTypeCodeLimit = 100
+
+	// otherTypeCode labels the events of typecodes beyond the typecode limit.
+	otherTypeCode = "other"
+)
+
+// typeCodeGuard bounds the number of typecode label values, so an unbounded set of
+// typecodes can't create an unbounded number of metric series.
+type typeCodeGuard struct {
+	mu    sync.Mutex
+	limit int
+	seen  map[string]bool
+}
+
+func newTypeCodeGuard(limit int) *typeCodeGuard {
+	return &typeCodeGuard{limit: limit, seen: make(map[string]bool)}
+}
+
+// label returns typeCode if it has been seen before or the limit is yet to be reached,
+// otherwise otherTypeCode.
+func (g *typeCodeGuard) label(typeCode string) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.seen[typeCode] {
+		return typeCode
+	}
+	if len(g.seen) >= g.limit {
+		return otherTypeCode
+	}
+
+	g.seen[typeCode] = true
+	return typeCode
+}
+
+// The processors create a store per event, so the guard is shared rather than
+// belonging to a store.
+var defaultTypeCodeGuard = newTypeCodeGuard(defaultTypeCodeLimit)
+
+// WithTypeCodeLimit sets the number of distinct typecodes the throughput metrics are
+// labelled with, 100 by default. Events with typecodes beyond the first limit seen
+// are labelled "other". Each call creates a new set of typecodes, shared by the
+// stores configured with the returned option.
+func WithTypeCodeLimit(limit int) StoreOption {
+	guard := newTypeCodeGuard(limit)
+	return func(s *Store) {
+		s.typeCodes = guard
+	}
+}
+
+// WithFeedName sets the name the store's throughput metrics are labelled with, to
+// tell apart feed chains held in separate tables. Defaults to "default".
+func WithFeedName(name string) StoreOption {
+	return func(s *Store) {
+		s.feedName = name
+	}
+}
@@ -0,0 +1,281 @@
+package esatompub
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/goes"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingMetrics struct {
+	mu   sync.Mutex
+	keys map[string]int
+}
+
+func newRecordingMetrics() *recordingMetrics {
+	return &recordingMetrics{keys: make(map[string]int)}
+}
+
+func (m *recordingMetrics) record(key []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys[strings.Join(key, ".")]++
+}
+
+func (m *recordingMetrics) count(key string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.keys[key]
+}
+
+func (m *recordingMetrics) SetGauge(key []string, val float32)    { m.record(key) }
+func (m *recordingMetrics) IncrCounter(key []string, val float32) { m.record(key) }
+func (m *recordingMetrics) AddSample(key []string, val float32)   { m.record(key) }
+
+func waitForCount(m *recordingMetrics, key string, count int) int {
+	deadline := time.Now().Add(time.Second)
+	for m.count(key) < count && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	return m.count(key)
+}
+
+func TestProcessorWithMetrics(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("lock table t_aefd_feed").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("select feedid from t_aefd_feed").WillReturnRows(sqlmock.NewRows([]string{"feedid"}).AddRow("XXX"))
+	mock.ExpectExec("insert into t_aeae_atom_event").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("select count").WillReturnRows(sqlmock.NewRows([]string{"count(*)", "max(id)"}).AddRow(FeedThreshold, 42))
+	mock.ExpectExec("update t_aeae_atom_event set feedid").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("insert into t_aefd_feed").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	m := newRecordingMetrics()
+	processor := NewESAtomPubProcessorWithMetrics(context.Background(), m)
+	err = processor.Processor(db, &goes.Event{Source: "agg1", Version: 1, TypeCode: "foo"})
+	assert.Nil(t, err)
+
+	//Samples and counters are both recorded for each key
+	assert.Equal(t, 2, waitForCount(m, "es-atom-data.process-event.ok", 2))
+	assert.Equal(t, 2, waitForCount(m, "es-atom-data.rollover", 2))
+	assert.Equal(t, 2, waitForCount(m, "es-atom-data.db.sqlInsertFeed", 2))
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestProcessorWithNilMetrics(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("lock table t_aefd_feed").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("select feedid from t_aefd_feed").WillReturnRows(sqlmock.NewRows([]string{"feedid"}).AddRow("XXX"))
+	mock.ExpectExec("insert into t_aeae_atom_event").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("select count").WillReturnRows(sqlmock.NewRows([]string{"count(*)", "max(id)"}).AddRow(1, 42))
+	mock.ExpectCommit()
+
+	processor := NewESAtomPubProcessorWithOptions(context.Background(), WithMetrics(nil))
+	assert.NotPanics(t, func() {
+		err = processor.Processor(db, &goes.Event{Source: "agg1", Version: 1, TypeCode: "foo"})
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, discardMetrics{}, newStore(nil, WithMetrics(nil)).metrics)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestInmemMetrics(t *testing.T) {
+	m, err := NewInmemMetrics()
+	if assert.Nil(t, err) {
+		m.IncrCounter([]string{"es-atom-data", "test"}, 1)
+	}
+}
+
+func TestStatsdMetrics(t *testing.T) {
+	m, err := NewStatsdMetrics("localhost:8125")
+	if assert.Nil(t, err) {
+		m.IncrCounter([]string{"es-atom-data", "test"}, 1)
+	}
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m, err := NewPrometheusMetrics(registry)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	m.AddSample([]string{"es-atom-data", "db", "sqlInsertFeed"}, 2)
+	m.AddSample([]string{"es-atom-data", "db", "sqlInsertFeed-error"}, 2)
+	m.IncrCounter([]string{"es-atom-data", "db", "sqlInsertFeed"}, 1)
+	m.AddSample([]string{"es-atom-data", "process-event", "ok"}, 5)
+	m.AddSample([]string{"es-atom-data", "rollover"}, 5)
+	m.IncrCounter([]string{"es-atom-data", "cache", "archive", "hit"}, 1)
+	m.IncrCounter([]string{"es-atom-data", "cache", "archive", "hit"}, 1)
+	m.SetGauge([]string{"es-atom-data", "reader", "lag"}, 3)
+
+	families, err := registry.Gather()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	found := make(map[string]int)
+	for _, family := range families {
+		found[family.GetName()] = len(family.GetMetric())
+		switch family.GetName() {
+		case "es_atom_data_cache_archive_hit_total":
+			assert.Equal(t, float64(2), family.GetMetric()[0].GetCounter().GetValue())
+		case "es_atom_data_reader_lag":
+			assert.Equal(t, float64(3), family.GetMetric()[0].GetGauge().GetValue())
+		case "es_atom_data_rollover_duration_seconds":
+			assert.Equal(t, uint64(1), family.GetMetric()[0].GetHistogram().GetSampleCount())
+		}
+	}
+
+	assert.Equal(t, 2, found["es_atom_data_db_duration_seconds"])
+	assert.Equal(t, 1, found["es_atom_data_process_event_duration_seconds"])
+	assert.Equal(t, 1, found["es_atom_data_rollover_duration_seconds"])
+	assert.Equal(t, 1, found["es_atom_data_cache_archive_hit_total"])
+	assert.Equal(t, 1, found["es_atom_data_reader_lag"])
+}
+
+func TestPrometheusMetricsKeyReuse(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	clash := prometheus.NewGauge(prometheus.GaugeOpts{Name: "es_atom_data_clash_total", Help: "clash"})
+	registry.MustRegister(clash)
+
+	m, err := NewPrometheusMetrics(registry)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	key := []string{"es-atom-data", "reused"}
+	assert.NotPanics(t, func() {
+		m.AddSample(key, 5)
+		m.IncrCounter(key, 1)
+		m.SetGauge(key, 3)
+		m.IncrCounterWithLabels(key, 1, []Label{{Name: "kind", Value: "x"}})
+		m.IncrCounter([]string{"es-atom-data", "clash"}, 1)
+		m.IncrCounter([]string{"es-atom-data", "clash"}, 1)
+	})
+
+	families, err := registry.Gather()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	found := make(map[string]int)
+	for _, family := range families {
+		found[family.GetName()] = len(family.GetMetric())
+		if family.GetName() == "es_atom_data_clash_total" {
+			assert.Equal(t, float64(0), family.GetMetric()[0].GetGauge().GetValue())
+		}
+	}
+
+	assert.Equal(t, 1, found["es_atom_data_reused_seconds"])
+	assert.Equal(t, 1, found["es_atom_data_reused_total"])
+	assert.Equal(t, 1, found["es_atom_data_reused"])
+	assert.Equal(t, 1, found["es_atom_data_clash_total"])
+}
+
+func TestDogStatsdMetrics(t *testing.T) {
+	m, err := NewDogStatsdMetrics("localhost:8125", "host1")
+	if assert.Nil(t, err) {
+		m.(LabeledMetrics).IncrCounterWithLabels([]string{"es-atom-data", "test"}, 1, []Label{{Name: "typecode", Value: "foo"}})
+	}
+}
+
+func TestPrometheusLabelledMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m, err := NewPrometheusMetrics(registry)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	foo := []Label{{Name: "typecode", Value: "foo"}, {Name: "feed", Value: "default"}, {Name: "outcome", Value: "ok"}}
+	bar := []Label{{Name: "typecode", Value: "bar"}, {Name: "feed", Value: "default"}, {Name: "outcome", Value: "ok"}}
+	m.IncrCounterWithLabels(eventsKey, 1, foo)
+	m.IncrCounterWithLabels(eventsKey, 1, foo)
+	m.IncrCounterWithLabels(eventsKey, 1, bar)
+	m.AddSampleWithLabels(payloadBytesKey, 300, foo)
+	m.IncrCounterWithLabels([]string{"es-atom-data", "other"}, 1, []Label{{Name: "kind", Value: "x"}})
+
+	families, err := registry.Gather()
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	found := make(map[string]int)
+	for _, family := range families {
+		found[family.GetName()] = len(family.GetMetric())
+		if family.GetName() == "es_atom_data_payload_bytes" {
+			assert.Equal(t, float64(300), family.GetMetric()[0].GetHistogram().GetSampleSum())
+		}
+	}
+
+	assert.Equal(t, 2, found["es_atom_data_events_total"])
+	assert.Equal(t, 1, found["es_atom_data_payload_bytes"])
+	assert.Equal(t, 1, found["es_atom_data_other_total"])
+}
+
+type labelledRecordingMetrics struct {
+	recordingMetrics
+	labels [][]Label
+}
+
+func (m *labelledRecordingMetrics) IncrCounterWithLabels(key []string, val float32, labels []Label) {
+	m.mu.Lock()
+	m.labels = append(m.labels, labels)
+	m.mu.Unlock()
+	m.record(key)
+}
+
+func (m *labelledRecordingMetrics) AddSampleWithLabels(key []string, val float32, labels []Label) {
+	m.record(key)
+}
+
+func (m *labelledRecordingMetrics) typeCodes() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var typeCodes []string
+	for _, labels := range m.labels {
+		typeCodes = append(typeCodes, labels[0].Value)
+	}
+	return typeCodes
+}
+
+func TestEventStatsTypeCodeLimit(t *testing.T) {
+	m := &labelledRecordingMetrics{recordingMetrics: recordingMetrics{keys: make(map[string]int)}}
+	store := newStore(nil, WithMetrics(m), WithTypeCodeLimit(2), WithFeedName("orders"))
+
+	for i, typeCode := range []string{"foo", "bar", "baz", "foo"} {
+		store.writeEventStats(&goes.Event{TypeCode: typeCode, Payload: []byte("ok")}, nil)
+		waitForCount(&m.recordingMetrics, "es-atom-data.events", i+1)
+	}
+
+	assert.Equal(t, 4, m.count("es-atom-data.events"))
+	assert.Equal(t, 4, waitForCount(&m.recordingMetrics, "es-atom-data.payload.bytes", 4))
+	assert.Equal(t, []string{"foo", "bar", "other", "foo"}, m.typeCodes())
+	assert.Equal(t, Label{Name: "feed", Value: "orders"}, m.labels[0][1])
+}
+
+func TestEventStatsWithoutLabels(t *testing.T) {
+	m := newRecordingMetrics()
+	newStore(nil, WithMetrics(m)).writeEventStats(&goes.Event{TypeCode: "foo", Payload: 42}, nil)
+
+	assert.Equal(t, 1, waitForCount(m, "es-atom-data.events", 1))
+	assert.Equal(t, 0, m.count("es-atom-data.payload.bytes"))
+}
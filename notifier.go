@@ -0,0 +1,137 @@
+package esatompub
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// NotificationKind identifies what a Notification reports.
+type NotificationKind int
+
+const (
+	// EventStored reports an event committed to the atom event table.
+	EventStored NotificationKind = iota
+
+	// FeedCreated reports the recent page being archived under a new feed id.
+	FeedCreated
+)
+
+// Notification describes a change committed by the event processor.
+type Notification struct {
+	Kind NotificationKind
+
+	// Event is the stored event for EventStored notifications. Its Timestamp is
+	// the time the processor committed the event, which can differ slightly from
+	// the event_time recorded by the database.
+	Event TimestampedEvent
+
+	// FeedID and Previous identify the new feed and its predecessor for
+	// FeedCreated notifications.
+	FeedID   string
+	Previous string
+}
+
+// DeliveryPolicy determines what happens when a subscriber's buffer is full.
+type DeliveryPolicy int
+
+const (
+	// DropWhenFull discards notifications a subscriber has no room for, so a slow
+	// reader never holds up the processor. Subscription.Dropped counts the losses.
+	DropWhenFull DeliveryPolicy = iota
+
+	// BlockWhenFull makes the processor wait until the subscriber has room.
+	BlockWhenFull
+)
+
+// Notifier fans notifications out to in-process subscribers.
+type Notifier struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+// DefaultNotifier receives the notifications published by processors created with
+// NewESAtomPubProcessor and NewESAtomPubProcessorContext.
+var DefaultNotifier = NewNotifier()
+
+// NewNotifier returns a notifier with no subscribers.
+func NewNotifier() *Notifier {
+	return &Notifier{
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscription receives notifications on C until it is closed.
+type Subscription struct {
+	C <-chan Notification
+
+	ch       chan Notification
+	done     chan struct{}
+	policy   DeliveryPolicy
+	notifier *Notifier
+	dropped  uint64
+	once     sync.Once
+}
+
+// Subscribe registers a subscriber whose channel buffers up to buffer notifications,
+// applying policy when the buffer is full.
+func (n *Notifier) Subscribe(buffer int, policy DeliveryPolicy) *Subscription {
+	ch := make(chan Notification, buffer)
+	sub := &Subscription{
+		C:        ch,
+		ch:       ch,
+		done:     make(chan struct{}),
+		policy:   policy,
+		notifier: n,
+	}
+
+	n.mu.Lock()
+	n.subs[sub] = struct{}{}
+	n.mu.Unlock()
+
+	return sub
+}
+
+// Publish delivers a notification to every subscriber.
+func (n *Notifier) Publish(notification Notification) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	for sub := range n.subs {
+		sub.deliver(notification)
+	}
+}
+
+func (s *Subscription) deliver(notification Notification) {
+	if s.policy == BlockWhenFull {
+		select {
+		case s.ch <- notification:
+		case <-s.done:
+		}
+		return
+	}
+
+	select {
+	case s.ch <- notification:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// Dropped returns the number of notifications discarded because the buffer was full.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close unsubscribes and closes C. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		//Release any publisher blocked on this subscriber before waiting for the lock
+		close(s.done)
+
+		s.notifier.mu.Lock()
+		delete(s.notifier.subs, s)
+		s.notifier.mu.Unlock()
+
+		close(s.ch)
+	})
+}
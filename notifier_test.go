@@ -0,0 +1,128 @@
+package esatompub
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/goes"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"testing"
+	"time"
+)
+
+func TestNotifierDropWhenFull(t *testing.T) {
+	notifier := NewNotifier()
+	sub := notifier.Subscribe(1, DropWhenFull)
+	defer sub.Close()
+
+	notifier.Publish(Notification{Kind: FeedCreated, FeedID: "one"})
+	notifier.Publish(Notification{Kind: FeedCreated, FeedID: "two"})
+
+	n := <-sub.C
+	assert.Equal(t, "one", n.FeedID)
+	assert.Equal(t, uint64(1), sub.Dropped())
+}
+
+func TestNotifierBlockWhenFull(t *testing.T) {
+	notifier := NewNotifier()
+	sub := notifier.Subscribe(0, BlockWhenFull)
+	defer sub.Close()
+
+	go notifier.Publish(Notification{Kind: FeedCreated, FeedID: "one"})
+
+	select {
+	case n := <-sub.C:
+		assert.Equal(t, "one", n.FeedID)
+	case <-time.After(time.Second):
+		t.Fatal("Expected blocked notification to be delivered")
+	}
+	assert.Equal(t, uint64(0), sub.Dropped())
+}
+
+func TestNotifierCloseReleasesBlockedPublisher(t *testing.T) {
+	notifier := NewNotifier()
+	sub := notifier.Subscribe(0, BlockWhenFull)
+
+	published := make(chan struct{})
+	go func() {
+		notifier.Publish(Notification{Kind: FeedCreated})
+		close(published)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	sub.Close()
+	sub.Close()
+
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatal("Publisher still blocked after subscription closed")
+	}
+
+	_, ok := <-sub.C
+	assert.False(t, ok)
+}
+
+func TestProcessorPublishesAfterCommit(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	eventPtr := &goes.Event{
+		Source:   "agg1",
+		Version:  1,
+		TypeCode: "foo",
+		Payload:  []byte("ok"),
+	}
+
+	tt := processTests[0]
+	testBeginSetup(mock, tt.beginOk)
+	testTableLockSetup(mock, tt.tableLockOk)
+	testFeedIdSelectSetup(mock, tt.feedIdSelectOk)
+	testEventInsertSetup(mock, tt.eventInsertOk, eventPtr)
+	testThresholdCountSetup(mock, tt.thesholdCountOk)
+	testThresholdAtomEventUpdateSetup(mock, tt.atomEventUpdateOk)
+	testFeedInsertOk(mock, tt.feedInsertOk)
+	testExpectCommitSetup(mock, tt.expectCommit)
+
+	notifier := NewNotifier()
+	sub := notifier.Subscribe(2, DropWhenFull)
+	defer sub.Close()
+
+	processor := NewESAtomPubProcessorWithNotifier(context.Background(), notifier)
+	err = processor.Processor(db, eventPtr)
+	if assert.Nil(t, err) {
+		stored := <-sub.C
+		assert.Equal(t, EventStored, stored.Kind)
+		assert.Equal(t, "agg1", stored.Event.Source)
+		assert.Equal(t, int64(42), stored.Event.Sequence)
+
+		created := <-sub.C
+		assert.Equal(t, FeedCreated, created.Kind)
+		assert.Equal(t, stored.Event.FeedID, created.FeedID)
+		assert.Equal(t, "XXX", created.Previous)
+	}
+}
+
+func TestProcessorDoesNotPublishOnError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	tt := processTests[2]
+	testBeginSetup(mock, tt.beginOk)
+	testTableLockSetup(mock, tt.tableLockOk)
+	testExpectCommitSetup(mock, tt.expectCommit)
+
+	notifier := NewNotifier()
+	sub := notifier.Subscribe(2, DropWhenFull)
+	defer sub.Close()
+
+	processor := NewESAtomPubProcessorWithNotifier(context.Background(), notifier)
+	err = processor.Processor(db, &goes.Event{Source: "agg1", Version: 1})
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, len(sub.C))
+}
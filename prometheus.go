@@ -0,0 +1,229 @@
+package esatompub
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// PrometheusMetrics exposes the package telemetry as Prometheus collectors. Timings are
+// recorded as histograms in seconds:
+//
+//	es_atom_data_db_duration_seconds{statement, outcome}   each SQL statement
+//	es_atom_data_process_event_duration_seconds{outcome}   each processed event
+//	es_atom_data_rollover_duration_seconds                 each feed rollover
+//
+// The histogram counts give the number of statements, events and rollovers. Events
+// are also counted, and their payload sizes recorded, by typecode, feed and outcome:
+//
+//	es_atom_data_events_total{typecode, feed, outcome}
+//	es_atom_data_payload_bytes{typecode, feed, outcome}
+//
+// Other counters and gauges, such as the archive cache hits, are registered as they
+// are first seen, named after their key and labels.
+type PrometheusMetrics struct {
+	registerer   prometheus.Registerer
+	db           *prometheus.HistogramVec
+	processEvent *prometheus.HistogramVec
+	rollover     prometheus.Histogram
+	events       *prometheus.CounterVec
+	payloadBytes *prometheus.HistogramVec
+
+	mu         sync.Mutex
+	collectors map[string]prometheus.Collector
+}
+
+// NewPrometheusMetrics returns metrics whose collectors are registered with registerer,
+// for example prometheus.DefaultRegisterer.
+func NewPrometheusMetrics(registerer prometheus.Registerer) (*PrometheusMetrics, error) {
+	m := &PrometheusMetrics{
+		registerer: registerer,
+		db: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "es_atom_data_db_duration_seconds",
+			Help: "Time taken by each SQL statement.",
+		}, []string{"statement", "outcome"}),
+		processEvent: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "es_atom_data_process_event_duration_seconds",
+			Help: "Time taken to process each event.",
+		}, []string{"outcome"}),
+		rollover: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name: "es_atom_data_rollover_duration_seconds",
+			Help: "Time taken to archive the recent page under a new feed.",
+		}),
+		events: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "es_atom_data_events_total",
+			Help: "Events processed.",
+		}, eventLabels),
+		payloadBytes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "es_atom_data_payload_bytes",
+			Help:    "Payload size of each processed event.",
+			Buckets: prometheus.ExponentialBuckets(64, 4, 8),
+		}, eventLabels),
+		collectors: make(map[string]prometheus.Collector),
+	}
+
+	for _, c := range []prometheus.Collector{m.db, m.processEvent, m.rollover, m.events, m.payloadBytes} {
+		if err := registerer.Register(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// AddSample records a duration, given in milliseconds.
+func (m *PrometheusMetrics) AddSample(key []string, val float32) {
+	seconds := float64(val) / 1000.0
+
+	switch {
+	case keyHasPrefix(key, "db") && len(key) == 3:
+		statement, outcome := splitOutcome(key[2])
+		m.db.WithLabelValues(statement, outcome).Observe(seconds)
+	case keyHasPrefix(key, "process-event") && len(key) == 3:
+		m.processEvent.WithLabelValues(key[2]).Observe(seconds)
+	case keyHasPrefix(key, "rollover"):
+		m.rollover.Observe(seconds)
+	default:
+		name := metricName(key) + "_seconds"
+		c := m.collector("histogram", name, func() prometheus.Collector {
+			return prometheus.NewHistogram(prometheus.HistogramOpts{Name: name, Help: strings.Join(key, ".")})
+		})
+		if h, ok := c.(prometheus.Histogram); ok {
+			h.Observe(seconds)
+		}
+	}
+}
+
+// IncrCounter increments a counter. Counts of the statements, events and rollovers
+// timed by AddSample are already held by the histograms, so are ignored here.
+func (m *PrometheusMetrics) IncrCounter(key []string, val float32) {
+	if keyHasPrefix(key, "db") || keyHasPrefix(key, "process-event") || keyHasPrefix(key, "rollover") {
+		return
+	}
+
+	name := metricName(key) + "_total"
+	c := m.collector("counter", name, func() prometheus.Collector {
+		return prometheus.NewCounter(prometheus.CounterOpts{Name: name, Help: strings.Join(key, ".")})
+	})
+	if counter, ok := c.(prometheus.Counter); ok {
+		counter.Add(float64(val))
+	}
+}
+
+// SetGauge sets a gauge.
+func (m *PrometheusMetrics) SetGauge(key []string, val float32) {
+	name := metricName(key)
+	c := m.collector("gauge", name, func() prometheus.Collector {
+		return prometheus.NewGauge(prometheus.GaugeOpts{Name: name, Help: strings.Join(key, ".")})
+	})
+	if gauge, ok := c.(prometheus.Gauge); ok {
+		gauge.Set(float64(val))
+	}
+}
+
+// IncrCounterWithLabels increments a labelled counter.
+func (m *PrometheusMetrics) IncrCounterWithLabels(key []string, val float32, labels []Label) {
+	if keyHasPrefix(key, "events") {
+		m.events.With(labelValues(labels)).Add(float64(val))
+		return
+	}
+
+	name := metricName(key) + "_total"
+	c := m.collector("counter"+labelledKind(labels), name, func() prometheus.Collector {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: strings.Join(key, ".")}, labelNames(labels))
+	})
+	if vec, ok := c.(*prometheus.CounterVec); ok {
+		vec.With(labelValues(labels)).Add(float64(val))
+	}
+}
+
+// AddSampleWithLabels records a labelled duration, given in milliseconds, or a
+// payload size in bytes.
+func (m *PrometheusMetrics) AddSampleWithLabels(key []string, val float32, labels []Label) {
+	if keyHasPrefix(key, "payload") {
+		m.payloadBytes.With(labelValues(labels)).Observe(float64(val))
+		return
+	}
+
+	name := metricName(key) + "_seconds"
+	c := m.collector("histogram"+labelledKind(labels), name, func() prometheus.Collector {
+		return prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: name, Help: strings.Join(key, ".")}, labelNames(labels))
+	})
+	if vec, ok := c.(*prometheus.HistogramVec); ok {
+		vec.With(labelValues(labels)).Observe(float64(val) / 1000.0)
+	}
+}
+
+var eventLabels = []string{"typecode", "feed", "outcome"}
+
+// labelledKind distinguishes the collectors for a name used with different label names.
+func labelledKind(labels []Label) string {
+	return "vec(" + strings.Join(labelNames(labels), ",") + ")"
+}
+
+func labelNames(labels []Label) []string {
+	names := make([]string, len(labels))
+	for i, label := range labels {
+		names[i] = label.Name
+	}
+	return names
+}
+
+func labelValues(labels []Label) prometheus.Labels {
+	values := make(prometheus.Labels, len(labels))
+	for _, label := range labels {
+		values[label.Name] = label.Value
+	}
+	return values
+}
+
+// collector returns the collector of the given kind and metric name, creating and
+// registering it on first use. Collectors are cached by kind as well as name, so a
+// name in use by a collector of another kind yields the already registered
+// collector only if it is of the same type. It returns nil when the collector can't
+// be registered, which is logged once, and the callers then drop the value.
+func (m *PrometheusMetrics) collector(kind string, name string, create func() prometheus.Collector) prometheus.Collector {
+	cacheKey := kind + ":" + name
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if c, ok := m.collectors[cacheKey]; ok {
+		return c
+	}
+
+	c := create()
+	if err := m.registerer.Register(c); err != nil {
+		are, ok := err.(prometheus.AlreadyRegisteredError)
+		switch {
+		case !ok:
+			defaultLogger.Warn("Unable to register Prometheus collector", "metric", name, "kind", kind, "error", err)
+			c = nil
+		case reflect.TypeOf(are.ExistingCollector) != reflect.TypeOf(c):
+			defaultLogger.Warn("Prometheus metric is registered as another kind", "metric", name, "kind", kind)
+			c = nil
+		default:
+			c = are.ExistingCollector
+		}
+	}
+
+	m.collectors[cacheKey] = c
+	return c
+}
+
+func keyHasPrefix(key []string, name string) bool {
+	return len(key) > 1 && key[0] == "es-atom-data" && key[1] == name
+}
+
+// splitOutcome splits the error suffix from a statement key such as sqlInsertFeed-error.
+func splitOutcome(statement string) (string, string) {
+	if strings.HasSuffix(statement, "-error") {
+		return strings.TrimSuffix(statement, "-error"), "error"
+	}
+	return statement, "ok"
+}
+
+func metricName(key []string) string {
+	return strings.NewReplacer("-", "_", ".", "_").Replace(strings.Join(key, "_"))
+}
@@ -0,0 +1,90 @@
+package esatompub
+
+import (
+	"fmt"
+	"strings"
+)
+
+// QueryOption narrows the events selected by a query.
+type QueryOption func(*queryOptions)
+
+type queryOptions struct {
+	fromVersion int
+	toVersion   int
+	typeCodes   []string
+	offset      int
+	limit       int
+}
+
+// WithVersionRange restricts an aggregate query to versions between from and to
+// inclusive. A bound of zero leaves that end of the range open.
+func WithVersionRange(from, to int) QueryOption {
+	return func(o *queryOptions) {
+		o.fromVersion = from
+		o.toVersion = to
+	}
+}
+
+// WithTypeCodes restricts a query to events with one of the given type codes.
+func WithTypeCodes(typeCodes ...string) QueryOption {
+	return func(o *queryOptions) {
+		o.typeCodes = typeCodes
+	}
+}
+
+// WithOffset skips the given number of matching events, for use with WithLimit
+// to page through large results.
+func WithOffset(offset int) QueryOption {
+	return func(o *queryOptions) {
+		o.offset = offset
+	}
+}
+
+// WithLimit caps the number of events a query returns. Zero means no limit.
+func WithLimit(limit int) QueryOption {
+	return func(o *queryOptions) {
+		o.limit = limit
+	}
+}
+
+func applyQueryOptions(opts []QueryOption) queryOptions {
+	var options queryOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+// bindArgs accumulates positional bind arguments for queries assembled at run time.
+type bindArgs []interface{}
+
+func (b *bindArgs) add(arg interface{}) string {
+	*b = append(*b, arg)
+	return fmt.Sprintf(":%d", len(*b))
+}
+
+func (b *bindArgs) typeCodeFilter(typeCodes []string) string {
+	if len(typeCodes) == 0 {
+		return ""
+	}
+
+	var binds []string
+	for _, typeCode := range typeCodes {
+		binds = append(binds, b.add(typeCode))
+	}
+
+	return " and typecode in (" + strings.Join(binds, ", ") + ")"
+}
+
+func (b *bindArgs) pageClause(options queryOptions) string {
+	var clause string
+	if options.offset > 0 {
+		clause += " offset " + b.add(options.offset) + " rows"
+	}
+
+	if options.limit > 0 {
+		clause += " fetch next " + b.add(options.limit) + " rows only"
+	}
+
+	return clause
+}
@@ -0,0 +1,123 @@
+package esatompub
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+const defaultReaderCheckInterval = 10 * time.Second
+
+// readerCheckTimeout bounds each measurement of the reader's lag.
+const readerCheckTimeout = 5 * time.Second
+
+// sqlFeedsAfter counts the feeds on the primary newer than the reader's latest feed.
+// A feed the primary doesn't know, or no feed at all, counts every feed.
+const sqlFeedsAfter = `select count(*) from t_aefd_feed where id > nvl((select id from t_aefd_feed where feedid = :1), 0)`
+
+// replica tracks a reader handle and whether it is current enough to query.
+type replica struct {
+	db            *sql.DB
+	stmts         map[string]*sql.Stmt
+	maxLag        int
+	checkInterval time.Duration
+
+	mu        sync.Mutex
+	checkedAt time.Time
+	checking  bool
+	fresh     bool
+}
+
+// WithReaderDB directs the store's queries to reader, for example an Active Data
+// Guard standby or a read replica, while events are still written through the
+// primary handle. The reader's staleness is measured in archive feeds by comparing
+// the latest feed on the reader with the feed chain on the primary. While the
+// reader trails the primary by more than maxLag feeds, queries fall back to the
+// primary.
+//
+// The recent page is not an archive feed, so within the bound the reader's recent
+// page can trail the primary by up to the feed threshold times maxLag plus one events.
+func WithReaderDB(reader *sql.DB, maxLag int) StoreOption {
+	return func(s *Store) {
+		s.replica = &replica{
+			db:     reader,
+			maxLag: maxLag,
+		}
+	}
+}
+
+// WithReaderCheckInterval sets how often the reader's lag is measured. Between
+// checks the last result is reused. It has no effect without WithReaderDB.
+func WithReaderCheckInterval(interval time.Duration) StoreOption {
+	return func(s *Store) {
+		s.readerCheck = interval
+	}
+}
+
+// usable reports whether queries may go to the reader, measuring its lag if the
+// last check is older than the check interval. The lag is measured outside the lock,
+// with its own timeout rather than the caller's context, and callers arriving while
+// a measurement is under way reuse the last result. If ctx is done before the
+// measurement completes the primary is used, and the measurement carries on for
+// later callers. Errors measuring the lag are treated as the reader being stale.
+func (r *replica) usable(ctx context.Context, s *Store) bool {
+	r.mu.Lock()
+	if r.checking || (!r.checkedAt.IsZero() && time.Now().Sub(r.checkedAt) < r.checkInterval) {
+		fresh := r.fresh
+		r.mu.Unlock()
+		return fresh
+	}
+	r.checking = true
+	r.mu.Unlock()
+
+	checked := make(chan bool, 1)
+	go func() { checked <- r.check(s) }()
+
+	select {
+	case fresh := <-checked:
+		return fresh
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// check measures the reader's lag and records the result.
+func (r *replica) check(s *Store) bool {
+	checkCtx, cancel := context.WithTimeout(context.Background(), readerCheckTimeout)
+	lag, err := r.lag(checkCtx, s)
+	cancel()
+	fresh := err == nil && lag <= r.maxLag
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err != nil {
+		s.logger.Warn("Unable to determine reader lag, using primary", "error", err)
+	} else {
+		go s.metrics.SetGauge([]string{"es-atom-data", "reader", "lag"}, float32(lag))
+		if !fresh && r.fresh {
+			s.logger.Warn("Reader is behind the primary, using primary", "lag", lag)
+		} else if fresh && !r.fresh && !r.checkedAt.IsZero() {
+			s.logger.Info("Reader has caught up with the primary, using reader", "lag", lag)
+		}
+	}
+
+	r.fresh = fresh
+	r.checkedAt = time.Now()
+	r.checking = false
+	return fresh
+}
+
+// lag returns the number of archive feeds on the primary the reader doesn't have yet.
+func (r *replica) lag(ctx context.Context, s *Store) (int, error) {
+	var readerLast sql.NullString
+	err := s.queryRowOn(ctx, r.db, r.stmts, sqlLatestFeedId).Scan(&readerLast)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	var lag int
+	err = s.queryRowOn(ctx, s.db, s.stmts, sqlFeedsAfter, readerLast).Scan(&lag)
+	return lag, err
+}
@@ -0,0 +1,124 @@
+package esatompub
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"testing"
+	"time"
+)
+
+func newReaderMocks(t *testing.T) (*sql.DB, sqlmock.Sqlmock, *sql.DB, sqlmock.Sqlmock) {
+	primary, primaryMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	reader, readerMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	return primary, primaryMock, reader, readerMock
+}
+
+func TestReaderWithinLagServesQueries(t *testing.T) {
+	primary, primaryMock, reader, readerMock := newReaderMocks(t)
+	defer primary.Close()
+	defer reader.Close()
+
+	readerMock.ExpectQuery(`select max\(id\)`).WillReturnRows(sqlmock.NewRows([]string{"feedid"}).AddRow("feed-2"))
+	primaryMock.ExpectQuery("select count").WithArgs("feed-2").WillReturnRows(sqlmock.NewRows([]string{"count(*)"}).AddRow(1))
+	readerMock.ExpectQuery(`select min\(id\)`).WillReturnRows(sqlmock.NewRows([]string{"feedid"}).AddRow("feed-1"))
+
+	store := newStore(primary, WithReaderDB(reader, 1))
+	first, err := store.RetrieveFirstFeedContext(context.Background())
+	if assert.Nil(t, err) {
+		assert.Equal(t, "feed-1", first)
+	}
+
+	assert.Nil(t, primaryMock.ExpectationsWereMet())
+	assert.Nil(t, readerMock.ExpectationsWereMet())
+}
+
+func TestReaderBeyondLagFallsBackToPrimary(t *testing.T) {
+	primary, primaryMock, reader, readerMock := newReaderMocks(t)
+	defer primary.Close()
+	defer reader.Close()
+
+	readerMock.ExpectQuery(`select max\(id\)`).WillReturnRows(sqlmock.NewRows([]string{"feedid"}).AddRow("feed-1"))
+	primaryMock.ExpectQuery("select count").WithArgs("feed-1").WillReturnRows(sqlmock.NewRows([]string{"count(*)"}).AddRow(3))
+	primaryMock.ExpectQuery(`select min\(id\)`).WillReturnRows(sqlmock.NewRows([]string{"feedid"}).AddRow("feed-1"))
+	primaryMock.ExpectQuery(`select min\(id\)`).WillReturnRows(sqlmock.NewRows([]string{"feedid"}).AddRow("feed-1"))
+
+	//The second query reuses the result of the first lag check
+	store := newStore(primary, WithReaderDB(reader, 1))
+	for i := 0; i < 2; i++ {
+		_, err := store.RetrieveFirstFeedContext(context.Background())
+		assert.Nil(t, err)
+	}
+
+	assert.Nil(t, primaryMock.ExpectationsWereMet())
+	assert.Nil(t, readerMock.ExpectationsWereMet())
+}
+
+func TestReaderErrorFallsBackToPrimary(t *testing.T) {
+	primary, primaryMock, reader, readerMock := newReaderMocks(t)
+	defer primary.Close()
+	defer reader.Close()
+
+	readerMock.ExpectQuery(`select max\(id\)`).WillReturnError(errors.New("standby unavailable"))
+	primaryMock.ExpectQuery(`select min\(id\)`).WillReturnRows(sqlmock.NewRows([]string{"feedid"}).AddRow("feed-1"))
+
+	store := newStore(primary, WithReaderDB(reader, 1))
+	first, err := store.RetrieveFirstFeedContext(context.Background())
+	if assert.Nil(t, err) {
+		assert.Equal(t, "feed-1", first)
+	}
+
+	assert.Nil(t, primaryMock.ExpectationsWereMet())
+	assert.Nil(t, readerMock.ExpectationsWereMet())
+}
+
+func TestReaderCheckIntervalBeforeReaderDB(t *testing.T) {
+	store := newStore(nil, WithReaderCheckInterval(time.Minute), WithReaderDB(nil, 1))
+	assert.Equal(t, time.Minute, store.replica.checkInterval)
+
+	store = newStore(nil, WithReaderDB(nil, 1))
+	assert.Equal(t, defaultReaderCheckInterval, store.replica.checkInterval)
+}
+
+func TestReaderLagCheckOutlivesCallerContext(t *testing.T) {
+	primary, primaryMock, reader, readerMock := newReaderMocks(t)
+	defer primary.Close()
+	defer reader.Close()
+
+	readerMock.ExpectQuery(`select max\(id\)`).WillReturnRows(sqlmock.NewRows([]string{"feedid"}).AddRow("feed-2"))
+	primaryMock.ExpectQuery("select count").WithArgs("feed-2").WillReturnRows(sqlmock.NewRows([]string{"count(*)"}).AddRow(0))
+
+	//A caller whose context is already done doesn't wait for the check, or fail it
+	//for everyone else
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	store := newStore(primary, WithReaderDB(reader, 1))
+	store.readHandle(ctx)
+
+	for i := 0; i < 100 && !checkedReader(store.replica); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	handle, _ := store.readHandle(context.Background())
+	assert.Equal(t, reader, handle)
+
+	assert.Nil(t, primaryMock.ExpectationsWereMet())
+	assert.Nil(t, readerMock.ExpectationsWereMet())
+}
+
+func checkedReader(r *replica) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return !r.checkedAt.IsZero()
+}
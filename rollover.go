@@ -0,0 +1,77 @@
+package esatompub
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// ForceRollover archives the events on the recent page under a new feed, without
+// waiting for the page to reach the feed threshold. It returns the new feed id, or
+// an empty string if the recent page was empty and no feed was created.
+func ForceRollover(db *sql.DB) (string, error) {
+	return ForceRolloverContext(context.Background(), db)
+}
+
+// ForceRolloverContext is like ForceRollover, but the work is bound to ctx.
+func ForceRolloverContext(ctx context.Context, db *sql.DB) (string, error) {
+	return newStore(db).ForceRolloverContext(ctx)
+}
+
+// ForceRolloverContext archives the recent page under a new feed under the feed table
+// lock, as the processor does when the page reaches the feed threshold, and publishes
+// FeedCreated to the store's notifier. The archived page is smaller than the feed
+// threshold, which VerifyContext reports as a page size anomaly.
+func (s *Store) ForceRolloverContext(ctx context.Context) (newFeedId string, err error) {
+	ctx, span := s.startSpan(ctx, "ForceRollover")
+	defer func() { endSpan(span, err) }()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if err = s.lockTable(ctx, tx); err != nil {
+		s.doRollback(tx)
+		return "", err
+	}
+
+	feedid, err := s.selectLatestFeed(ctx, tx)
+	if err != nil {
+		s.doRollback(tx)
+		return "", err
+	}
+
+	count, _, err := s.getRecentFeedCount(ctx, tx)
+	if err != nil {
+		s.doRollback(tx)
+		return "", err
+	}
+
+	if count == 0 {
+		s.logger.Info("Recent page is empty, no feed created")
+		return "", tx.Commit()
+	}
+
+	s.logger.Info("Forcing rollover", "count", count, LogFieldPrevious, feedid.String)
+	start := time.Now()
+	newFeedId, err = s.createNewFeed(ctx, tx, feedid)
+	if err != nil {
+		s.doRollback(tx)
+		return "", err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", err
+	}
+	s.writeRolloverStats(start)
+	span.SetAttributes(AttributeFeedID.String(newFeedId))
+
+	s.notifier.Publish(Notification{
+		Kind:     FeedCreated,
+		FeedID:   newFeedId,
+		Previous: feedid.String,
+	})
+
+	return newFeedId, nil
+}
@@ -0,0 +1,59 @@
+package esatompub
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"testing"
+)
+
+func expectRolloverQueries(mock sqlmock.Sqlmock, recent int) {
+	mock.ExpectBegin()
+	mock.ExpectExec("lock table t_aefd_feed").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("select feedid from t_aefd_feed").WillReturnRows(sqlmock.NewRows([]string{"feedid"}).AddRow("f1"))
+	mock.ExpectQuery("select count").WillReturnRows(sqlmock.NewRows([]string{"count(*)", "max(id)"}).AddRow(recent, 42))
+}
+
+func TestForceRollover(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	expectRolloverQueries(mock, 3)
+	mock.ExpectExec("update t_aeae_atom_event set feedid").WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec("insert into t_aefd_feed").WithArgs(sqlmock.AnyArg(), "f1").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	notifier := NewNotifier()
+	sub := notifier.Subscribe(1, DropWhenFull)
+	defer sub.Close()
+
+	feedid, err := newStore(db, WithNotifier(notifier), WithFeedThreshold(100)).ForceRolloverContext(context.Background())
+	if assert.Nil(t, err) {
+		assert.NotEqual(t, "", feedid)
+
+		created := <-sub.C
+		assert.Equal(t, FeedCreated, created.Kind)
+		assert.Equal(t, feedid, created.FeedID)
+		assert.Equal(t, "f1", created.Previous)
+	}
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestForceRolloverEmptyRecentPage(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	expectRolloverQueries(mock, 0)
+	mock.ExpectCommit()
+
+	feedid, err := ForceRollover(db)
+	assert.Nil(t, err)
+	assert.Equal(t, "", feedid)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
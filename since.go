@@ -0,0 +1,74 @@
+package esatompub
+
+import (
+	"context"
+	"database/sql"
+)
+
+const (
+	sqlSelectSince = `select id, feedid, event_time, aggregate_id, version, typecode, payload from t_aeae_atom_event where id > :1 order by id`
+	sqlSelectHead  = `select nvl(max(id), 0) from t_aeae_atom_event`
+)
+
+// RetrieveSince returns up to limit events written after the given sequence position,
+// oldest first, spanning both archived and recent events. The boolean result is true
+// when the returned events reach the head of the stream, that is when no further
+// events have been written yet. A limit of zero returns every event after position.
+//
+// Events are written while holding the feed table lock, so sequence positions are
+// assigned in commit order and a checkpoint never skips over a later commit.
+func RetrieveSince(db *sql.DB, position int64, limit int) ([]TimestampedEvent, bool, error) {
+	return RetrieveSinceContext(context.Background(), db, position, limit)
+}
+
+// RetrieveSinceContext is like RetrieveSince, but the query is bound to ctx.
+func RetrieveSinceContext(ctx context.Context, db *sql.DB, position int64, limit int) ([]TimestampedEvent, bool, error) {
+	return newStore(db).RetrieveSinceContext(ctx, position, limit)
+}
+
+// RetrieveSinceContext is like the package level RetrieveSince, but the query is run
+// through the store and bound to ctx.
+func (s *Store) RetrieveSinceContext(ctx context.Context, position int64, limit int) (events []TimestampedEvent, atHead bool, err error) {
+	ctx, span := s.startSpan(ctx, "RetrieveSince")
+	defer func() { endSpan(span, err) }()
+
+	args := bindArgs{position}
+	query := sqlSelectSince
+
+	if limit > 0 {
+		//Read one extra row to find out if there's more to come
+		query += args.pageClause(queryOptions{limit: limit + 1})
+	}
+
+	events, err = s.retrieveEvents(ctx, query, args...)
+	if err != nil {
+		return events, false, err
+	}
+
+	if limit > 0 && len(events) > limit {
+		return events[:limit], false, nil
+	}
+
+	return events, true, nil
+}
+
+// RetrieveHead returns the sequence position of the newest event, or zero if no events
+// have been written. Passing it to RetrieveSince reads only the events written later.
+func RetrieveHead(db *sql.DB) (int64, error) {
+	return RetrieveHeadContext(context.Background(), db)
+}
+
+// RetrieveHeadContext is like RetrieveHead, but the query is bound to ctx.
+func RetrieveHeadContext(ctx context.Context, db *sql.DB) (int64, error) {
+	return newStore(db).RetrieveHeadContext(ctx)
+}
+
+// RetrieveHeadContext returns the sequence position of the newest event, or zero if no
+// events have been written.
+func (s *Store) RetrieveHeadContext(ctx context.Context) (position int64, err error) {
+	ctx, span := s.startSpan(ctx, "RetrieveHead")
+	defer func() { endSpan(span, err) }()
+
+	err = s.queryRow(ctx, sqlSelectHead).Scan(&position)
+	return position, err
+}
@@ -0,0 +1,105 @@
+package esatompub
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"testing"
+	"time"
+)
+
+func sinceRows(from, to int64) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"id", "feedid", "event_time", "aggregate_id",
+		"version", "typecode", "payload"})
+	for i := from; i <= to; i++ {
+		rows.AddRow(i, "feed-1", time.Now(), "agg1", int(i), "foo", []byte("ok"))
+	}
+	return rows
+}
+
+func TestRetrieveSinceMoreToCome(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`where id > :1 order by id fetch next :2 rows only`).
+		WithArgs(int64(10), 3).WillReturnRows(sinceRows(11, 13))
+
+	events, atHead, err := RetrieveSince(db, 10, 2)
+	if assert.Nil(t, err) {
+		assert.Nil(t, mock.ExpectationsWereMet())
+		assert.False(t, atHead)
+		if assert.Equal(t, 2, len(events)) {
+			assert.Equal(t, int64(11), events[0].Sequence)
+			assert.Equal(t, int64(12), events[1].Sequence)
+		}
+	}
+}
+
+func TestRetrieveSinceAtHead(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`where id > :1`).WithArgs(int64(10), 3).WillReturnRows(sinceRows(11, 12))
+
+	events, atHead, err := RetrieveSince(db, 10, 2)
+	if assert.Nil(t, err) {
+		assert.True(t, atHead)
+		assert.Equal(t, 2, len(events))
+	}
+}
+
+func TestRetrieveSinceNoLimit(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`where id > :1 order by id$`).WithArgs(int64(0)).WillReturnRows(sinceRows(1, 5))
+
+	events, atHead, err := RetrieveSince(db, 0, 0)
+	if assert.Nil(t, err) {
+		assert.Nil(t, mock.ExpectationsWereMet())
+		assert.True(t, atHead)
+		assert.Equal(t, 5, len(events))
+	}
+}
+
+func TestRetrieveSinceError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`where id > :1`).WillReturnError(errors.New("boom"))
+
+	_, atHead, err := RetrieveSince(db, 10, 2)
+	if assert.NotNil(t, err) {
+		assert.False(t, atHead)
+		assert.Equal(t, "boom", err.Error())
+	}
+}
+
+func TestRetrieveHead(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`select nvl\(max\(id\), 0\) from t_aeae_atom_event`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+
+	head, err := RetrieveHead(db)
+	if assert.Nil(t, err) {
+		assert.Equal(t, int64(42), head)
+	}
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,264 @@
+package esatompub
+
+import (
+	"context"
+	"database/sql"
+	"github.com/xtracdev/goes"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
+	"time"
+)
+
+// Reader is the query side of the atom data store.
+type Reader interface {
+	RetrieveRecentContext(ctx context.Context) ([]TimestampedEvent, error)
+	RetrieveArchiveContext(ctx context.Context, feedid string) ([]TimestampedEvent, error)
+	RetrieveLastFeedContext(ctx context.Context) (string, error)
+	RetrieveFirstFeedContext(ctx context.Context) (string, error)
+	RetrievePreviousFeedContext(ctx context.Context, id string) (sql.NullString, error)
+	RetrieveNextFeedContext(ctx context.Context, feedId string) (sql.NullString, error)
+	RetrieveEventContext(ctx context.Context, aggID string, version int) (TimestampedEvent, error)
+	RetrieveAggregateEventsContext(ctx context.Context, aggregateID string, opts ...QueryOption) ([]TimestampedEvent, error)
+	RetrieveTimeRangeContext(ctx context.Context, from, to time.Time, opts ...QueryOption) ([]TimestampedEvent, error)
+	RetrieveFeedsInRangeContext(ctx context.Context, from, to time.Time) ([]string, error)
+	RetrieveSinceContext(ctx context.Context, position int64, limit int) ([]TimestampedEvent, bool, error)
+	RetrieveHeadContext(ctx context.Context) (int64, error)
+	RetrieveFeedInfoContext(ctx context.Context, feedid string) (FeedInfo, error)
+	ListFeedsContext(ctx context.Context, opts ...QueryOption) ([]FeedInfo, error)
+}
+
+// Writer is the event processing side of the atom data store.
+type Writer interface {
+	ProcessEventContext(ctx context.Context, event *goes.Event) error
+}
+
+// Store reads and writes the atom event and feed tables through a single database
+// handle, using statements prepared once when the store is created. A Store is safe
+// for concurrent use.
+type Store struct {
+	db            *sql.DB
+	notifier      *Notifier
+	feedThreshold int
+	stmts         map[string]*sql.Stmt
+	replica       *replica
+	readerCheck   time.Duration
+	metrics       Metrics
+	tracer        trace.Tracer
+	logger        Logger
+	eventTime     func(event *goes.Event) (time.Time, bool)
+	typeCodes     *typeCodeGuard
+	feedName      string
+	tables        *strings.Replacer
+}
+
+// StoreOption configures a Store.
+type StoreOption func(*Store)
+
+// WithNotifier sets the notifier the store publishes stored events and new feeds to.
+// DefaultNotifier is used if this option is not given.
+func WithNotifier(notifier *Notifier) StoreOption {
+	return func(s *Store) {
+		s.notifier = notifier
+	}
+}
+
+// WithMetrics sets the metrics the store emits its telemetry to. By default the
+// armon/go-metrics global is used. A nil m discards the telemetry.
+func WithMetrics(m Metrics) StoreOption {
+	return func(s *Store) {
+		if m == nil {
+			m = discardMetrics{}
+		}
+		s.metrics = m
+	}
+}
+
+// WithFeedThreshold sets the number of events per archive feed for the store,
+// overriding the package level FeedThreshold.
+func WithFeedThreshold(threshold int) StoreOption {
+	return func(s *Store) {
+		s.feedThreshold = threshold
+	}
+}
+
+// The statements that don't vary with query options are prepared up front. Only the
+// read statements are prepared against a reader handle. Statements must name the
+// tables as DefaultEventTable, DefaultFeedTable and DefaultBackfillTable spell them,
+// so that configured table names can be substituted.
+var writeStatements = []string{
+	sqlInsertEventIntoFeed,
+	sqlRecentFeedCount,
+	sqlUpdateFeedIds,
+	sqlInsertFeed,
+	sqlLockTable,
+}
+
+var readStatements = []string{
+	sqlLatestFeedId,
+	sqlSelectRecent,
+	sqlSelectForFeed,
+	sqlSelectPreviousFeed,
+	sqlSelectNextFeed,
+	sqlSelectEvent,
+	sqlFeedExists,
+	sqlSelectFirstFeed,
+	sqlSelectHistory,
+	sqlSelectFeedsInRange,
+	sqlSelectFeedInfo,
+}
+
+var preparedStatements = append(append([]string(nil), writeStatements...), readStatements...)
+
+// NewStore returns a store using db, preparing its statements.
+func NewStore(db *sql.DB, opts ...StoreOption) (*Store, error) {
+	s := newStore(db, opts...)
+
+	stmts, err := s.prepareStatements(db, preparedStatements)
+	if err != nil {
+		return nil, err
+	}
+	s.stmts = stmts
+
+	if s.replica != nil {
+		s.replica.stmts, err = s.prepareStatements(s.replica.db, readStatements)
+		if err != nil {
+			closeStatements(s.stmts)
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// prepareStatements prepares queries against db, keyed by the query before any table
+// names are substituted.
+func (s *Store) prepareStatements(db *sql.DB, queries []string) (map[string]*sql.Stmt, error) {
+	stmts := make(map[string]*sql.Stmt)
+	for _, query := range queries {
+		stmt, err := db.Prepare(s.sqlFor(query))
+		if err != nil {
+			closeStatements(stmts)
+			return nil, err
+		}
+		stmts[query] = stmt
+	}
+
+	return stmts, nil
+}
+
+// newStore returns a store without prepared statements, as used by the package
+// level functions.
+func newStore(db *sql.DB, opts ...StoreOption) *Store {
+	s := &Store{
+		db:        db,
+		notifier:  DefaultNotifier,
+		metrics:   defaultMetrics,
+		logger:    defaultLogger,
+		typeCodes: defaultTypeCodeGuard,
+		feedName:  "default",
+
+		readerCheck: defaultReaderCheckInterval,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.replica != nil {
+		s.replica.checkInterval = s.readerCheck
+	}
+
+	return s
+}
+
+// Close releases the store's prepared statements. It does not close the database handles.
+func (s *Store) Close() error {
+	err := closeStatements(s.stmts)
+	if s.replica != nil {
+		if replicaErr := closeStatements(s.replica.stmts); err == nil {
+			err = replicaErr
+		}
+	}
+	return err
+}
+
+func closeStatements(stmts map[string]*sql.Stmt) error {
+	var firstErr error
+	for _, stmt := range stmts {
+		if err := stmt.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (s *Store) threshold() int {
+	if s.feedThreshold > 0 {
+		return s.feedThreshold
+	}
+	return FeedThreshold
+}
+
+// sqlFor returns query with the store's table names substituted for the default
+// t_aeae_atom_event, t_aefd_feed and t_aebf_backfill. The substitution is textual,
+// so a table spelled any other way in query is left unchanged.
+func (s *Store) sqlFor(query string) string {
+	if s.tables == nil {
+		return query
+	}
+	return s.tables.Replace(query)
+}
+
+// readHandle returns the reader handle while it is within the lag bound, and the
+// primary otherwise.
+func (s *Store) readHandle(ctx context.Context) (*sql.DB, map[string]*sql.Stmt) {
+	if s.replica != nil && s.replica.usable(ctx, s) {
+		return s.replica.db, s.replica.stmts
+	}
+	return s.db, s.stmts
+}
+
+func (s *Store) query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	db, stmts := s.readHandle(ctx)
+	return s.queryOn(ctx, db, stmts, query, args...)
+}
+
+func (s *Store) queryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	db, stmts := s.readHandle(ctx)
+	return s.queryRowOn(ctx, db, stmts, query, args...)
+}
+
+func (s *Store) queryOn(ctx context.Context, db *sql.DB, stmts map[string]*sql.Stmt, query string, args ...interface{}) (*sql.Rows, error) {
+	if stmt, ok := stmts[query]; ok {
+		return stmt.QueryContext(ctx, args...)
+	}
+	return db.QueryContext(ctx, s.sqlFor(query), args...)
+}
+
+func (s *Store) queryRowOn(ctx context.Context, db *sql.DB, stmts map[string]*sql.Stmt, query string, args ...interface{}) *sql.Row {
+	if stmt, ok := stmts[query]; ok {
+		return stmt.QueryRowContext(ctx, args...)
+	}
+	return db.QueryRowContext(ctx, s.sqlFor(query), args...)
+}
+
+func (s *Store) queryTx(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) (*sql.Rows, error) {
+	if stmt, ok := s.stmts[query]; ok {
+		return tx.StmtContext(ctx, stmt).QueryContext(ctx, args...)
+	}
+	return tx.QueryContext(ctx, s.sqlFor(query), args...)
+}
+
+func (s *Store) queryRowTx(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) *sql.Row {
+	if stmt, ok := s.stmts[query]; ok {
+		return tx.StmtContext(ctx, stmt).QueryRowContext(ctx, args...)
+	}
+	return tx.QueryRowContext(ctx, s.sqlFor(query), args...)
+}
+
+func (s *Store) execTx(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) (sql.Result, error) {
+	if stmt, ok := s.stmts[query]; ok {
+		return tx.StmtContext(ctx, stmt).ExecContext(ctx, args...)
+	}
+	return tx.ExecContext(ctx, s.sqlFor(query), args...)
+}
@@ -0,0 +1,101 @@
+package esatompub
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/goes"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func expectPrepareAll(mock sqlmock.Sqlmock) {
+	for _, query := range preparedStatements {
+		mock.ExpectPrepare(regexp.QuoteMeta(query))
+	}
+}
+
+func TestNewStorePrepareError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectPrepare(regexp.QuoteMeta(preparedStatements[0]))
+	mock.ExpectPrepare(regexp.QuoteMeta(preparedStatements[1])).WillReturnError(errors.New("BAM!"))
+
+	_, err = NewStore(db)
+	assert.NotNil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestStoreQueriesUsePreparedStatements(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	expectPrepareAll(mock)
+
+	rows := sqlmock.NewRows([]string{"id", "feedid", "event_time", "aggregate_id",
+		"version", "typecode", "payload"},
+	).AddRow(int64(1), nil, time.Now(), "agg1", 1, "foo", []byte("one"))
+	mock.ExpectQuery(regexp.QuoteMeta(sqlSelectRecent)).WillReturnRows(rows)
+
+	store, err := NewStore(db)
+	if assert.Nil(t, err) {
+		defer store.Close()
+
+		events, err := store.RetrieveRecentContext(context.Background())
+		if assert.Nil(t, err) {
+			assert.Equal(t, 1, len(events))
+		}
+	}
+
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestStoreProcessEventWithFeedThreshold(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	expectPrepareAll(mock)
+	mock.ExpectBegin()
+	mock.ExpectExec("lock table t_aefd_feed").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("select feedid from t_aefd_feed").WillReturnRows(sqlmock.NewRows([]string{"feedid"}).AddRow("XXX"))
+	mock.ExpectExec("insert into t_aeae_atom_event").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("select count").WillReturnRows(sqlmock.NewRows([]string{"count(*)", "max(id)"}).AddRow(1, 42))
+	mock.ExpectExec("update t_aeae_atom_event set feedid").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("insert into t_aefd_feed").WithArgs(sqlmock.AnyArg(), "XXX").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	notifier := NewNotifier()
+	sub := notifier.Subscribe(2, DropWhenFull)
+	defer sub.Close()
+
+	store, err := NewStore(db, WithNotifier(notifier), WithFeedThreshold(1))
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer store.Close()
+
+	processor := NewWriterProcessor(context.Background(), store)
+	err = processor.Processor(nil, &goes.Event{Source: "agg1", Version: 1, TypeCode: "foo", Payload: []byte("ok")})
+	if assert.Nil(t, err) {
+		stored := <-sub.C
+		assert.Equal(t, int64(42), stored.Event.Sequence)
+
+		created := <-sub.C
+		assert.Equal(t, FeedCreated, created.Kind)
+		assert.Equal(t, "XXX", created.Previous)
+	}
+
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,104 @@
+package esatompub
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+const (
+	sqlSelectTimeRange    = `select id, feedid, event_time, aggregate_id, version, typecode, payload from t_aeae_atom_event where event_time >= :1 and event_time < :2`
+	sqlSelectFeedsInRange = `select feedid from t_aeae_atom_event where event_time >= :1 and event_time < :2 and feedid is not null group by feedid order by min(id)`
+)
+
+func timeRangeQuery(from, to time.Time, options queryOptions) (string, []interface{}) {
+	args := bindArgs{from, to}
+	query := sqlSelectTimeRange
+	query += args.typeCodeFilter(options.typeCodes)
+	query += " order by id"
+	query += args.pageClause(options)
+
+	return query, args
+}
+
+// RetrieveTimeRange returns the events stored at or after from and before to, in
+// the order they were written. WithTypeCodes, WithOffset and WithLimit narrow the
+// results; WithVersionRange is ignored.
+func RetrieveTimeRange(db *sql.DB, from, to time.Time, opts ...QueryOption) ([]TimestampedEvent, error) {
+	return RetrieveTimeRangeContext(context.Background(), db, from, to, opts...)
+}
+
+// RetrieveTimeRangeContext is like RetrieveTimeRange, but the query is bound to ctx.
+func RetrieveTimeRangeContext(ctx context.Context, db *sql.DB, from, to time.Time, opts ...QueryOption) ([]TimestampedEvent, error) {
+	return newStore(db).RetrieveTimeRangeContext(ctx, from, to, opts...)
+}
+
+// RetrieveTimeRangeContext is like the package level RetrieveTimeRange, but the query
+// is run through the store and bound to ctx.
+func (s *Store) RetrieveTimeRangeContext(ctx context.Context, from, to time.Time, opts ...QueryOption) (events []TimestampedEvent, err error) {
+	o := applyQueryOptions(opts)
+
+	ctx, span := s.startSpan(ctx, "RetrieveTimeRange", AttributeTypeCode.StringSlice(o.typeCodes))
+	defer func() { endSpan(span, err) }()
+
+	query, args := timeRangeQuery(from, to, o)
+	return s.retrieveEvents(ctx, query, args...)
+}
+
+// IterateTimeRange returns an iterator over the events stored at or after from and before to.
+func IterateTimeRange(db *sql.DB, from, to time.Time, opts ...QueryOption) (*EventIterator, error) {
+	return IterateTimeRangeContext(context.Background(), db, from, to, opts...)
+}
+
+// IterateTimeRangeContext is like IterateTimeRange, but the query is bound to ctx.
+func IterateTimeRangeContext(ctx context.Context, db *sql.DB, from, to time.Time, opts ...QueryOption) (*EventIterator, error) {
+	return newStore(db).IterateTimeRangeContext(ctx, from, to, opts...)
+}
+
+// IterateTimeRangeContext is like the package level IterateTimeRange, but the query
+// is run through the store and bound to ctx.
+func (s *Store) IterateTimeRangeContext(ctx context.Context, from, to time.Time, opts ...QueryOption) (*EventIterator, error) {
+	query, args := timeRangeQuery(from, to, applyQueryOptions(opts))
+	return s.iterateEvents(ctx, query, args...)
+}
+
+// RetrieveFeedsInRange returns the ids of the archive feeds holding events stored at
+// or after from and before to, oldest feed first. Events in the range that have not
+// yet been archived are on the recent page, which has no feed id.
+func RetrieveFeedsInRange(db *sql.DB, from, to time.Time) ([]string, error) {
+	return RetrieveFeedsInRangeContext(context.Background(), db, from, to)
+}
+
+// RetrieveFeedsInRangeContext is like RetrieveFeedsInRange, but the query is bound to ctx.
+func RetrieveFeedsInRangeContext(ctx context.Context, db *sql.DB, from, to time.Time) ([]string, error) {
+	return newStore(db).RetrieveFeedsInRangeContext(ctx, from, to)
+}
+
+// RetrieveFeedsInRangeContext is like the package level RetrieveFeedsInRange, but the
+// query is run through the store and bound to ctx.
+func (s *Store) RetrieveFeedsInRangeContext(ctx context.Context, from, to time.Time) (feedids []string, err error) {
+	ctx, span := s.startSpan(ctx, "RetrieveFeedsInRange")
+	defer func() { endSpan(span, err) }()
+
+	rows, err := s.query(ctx, sqlSelectFeedsInRange, from, to)
+	if err != nil {
+		return feedids, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var feedid string
+		if err = rows.Scan(&feedid); err != nil {
+			return feedids, err
+		}
+
+		feedids = append(feedids, feedid)
+	}
+
+	if err = rows.Err(); err != nil {
+		return feedids, err
+	}
+
+	return feedids, nil
+}
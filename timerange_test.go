@@ -0,0 +1,79 @@
+package esatompub
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"testing"
+	"time"
+)
+
+func TestTimeRangeQuery(t *testing.T) {
+	from := time.Date(2017, 1, 5, 2, 0, 0, 0, time.UTC)
+	to := from.Add(15 * time.Minute)
+
+	query, args := timeRangeQuery(from, to,
+		applyQueryOptions([]QueryOption{WithTypeCodes("foo"), WithLimit(100)}))
+	assert.Equal(t, sqlSelectTimeRange+" and typecode in (:3) order by id fetch next :4 rows only", query)
+	assert.Equal(t, []interface{}{from, to, "foo", 100}, args)
+}
+
+func TestRetrieveTimeRange(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	from := time.Date(2017, 1, 5, 2, 0, 0, 0, time.UTC)
+	to := from.Add(15 * time.Minute)
+
+	rows := sqlmock.NewRows([]string{"id", "feedid", "event_time", "aggregate_id",
+		"version", "typecode", "payload"},
+	).AddRow(int64(1), nil, from.Add(time.Minute), "agg1", 1, "foo", []byte("one"))
+	mock.ExpectQuery("select .* where event_time >= :1 and event_time < :2 order by id offset :3 rows").
+		WithArgs(from, to, 50).WillReturnRows(rows)
+
+	events, err := RetrieveTimeRange(db, from, to, WithOffset(50))
+	if assert.Nil(t, err) {
+		assert.Nil(t, mock.ExpectationsWereMet())
+		if assert.Equal(t, 1, len(events)) {
+			assert.Equal(t, "agg1", events[0].Source)
+		}
+	}
+}
+
+func TestRetrieveFeedsInRange(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	from := time.Now().Add(-time.Hour)
+	to := time.Now()
+
+	rows := sqlmock.NewRows([]string{"feedid"}).AddRow("feed-1").AddRow("feed-2")
+	mock.ExpectQuery("select feedid").WithArgs(from, to).WillReturnRows(rows)
+
+	feedids, err := RetrieveFeedsInRange(db, from, to)
+	if assert.Nil(t, err) {
+		assert.Nil(t, mock.ExpectationsWereMet())
+		assert.Equal(t, []string{"feed-1", "feed-2"}, feedids)
+	}
+}
+
+func TestRetrieveFeedsInRangeError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("select feedid").WillReturnError(errors.New("boom"))
+
+	_, err = RetrieveFeedsInRange(db, time.Now(), time.Now())
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "boom", err.Error())
+	}
+}
@@ -0,0 +1,77 @@
+package esatompub
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"os"
+)
+
+const tracerName = "github.com/xtracdev/es-atom-data"
+
+// Span attribute keys.
+const (
+	AttributeAggregateID = attribute.Key("es.aggregate_id")
+	AttributeVersion     = attribute.Key("es.version")
+	AttributeTypeCode    = attribute.Key("es.typecode")
+	AttributeFeedID      = attribute.Key("es.feed_id")
+)
+
+// WithTracerProvider sets the provider of the tracer the store records spans with.
+// By default the global provider registered with otel.SetTracerProvider is used, which
+// records nothing until one is registered. Spans are children of any span in the
+// context passed to each call.
+func WithTracerProvider(tp trace.TracerProvider) StoreOption {
+	return func(s *Store) {
+		s.tracer = tp.Tracer(tracerName)
+	}
+}
+
+// TracerProviderFromEnv returns a tracer provider for the exporter named by the
+// TRACE_EXPORTER environment variable. The stdout exporter writes each span to
+// standard out as JSON, which is handy when working locally. When TRACE_EXPORTER
+// is empty or none, the global tracer provider is returned.
+func TracerProviderFromEnv() (trace.TracerProvider, error) {
+	switch exporter := os.Getenv("TRACE_EXPORTER"); exporter {
+	case "", "none":
+		return otel.GetTracerProvider(), nil
+	case "stdout":
+		exp, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		if err != nil {
+			return nil, err
+		}
+		return sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp)), nil
+	default:
+		return nil, fmt.Errorf("unsupported TRACE_EXPORTER %s", exporter)
+	}
+}
+
+func (s *Store) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	tracer := s.tracer
+	if tracer == nil {
+		tracer = otel.Tracer(tracerName)
+	}
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// startStatementSpan starts a span for one of the processor's SQL statements, named
+// by the same key as its timing stats.
+func (s *Store) startStatementSpan(ctx context.Context, name string, query string) (context.Context, trace.Span) {
+	return s.startSpan(ctx, name,
+		attribute.String("db.system", "oracle"),
+		attribute.String("db.statement", s.sqlFor(query)),
+	)
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
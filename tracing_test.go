@@ -0,0 +1,121 @@
+package esatompub
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/xtracdev/goes"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"os"
+	"testing"
+	"time"
+)
+
+func spanAttribute(span tracetest.SpanStub, key attribute.Key) string {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestProcessEventSpans(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("lock table t_aefd_feed").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("select feedid from t_aefd_feed").WillReturnRows(sqlmock.NewRows([]string{"feedid"}).AddRow("XXX"))
+	mock.ExpectExec("insert into t_aeae_atom_event").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("select count").WillReturnRows(sqlmock.NewRows([]string{"count(*)", "max(id)"}).AddRow(1, 42))
+	mock.ExpectExec("update t_aeae_atom_event set feedid").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("insert into t_aefd_feed").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	//Spans should join the trace in the caller's context
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "caller")
+
+	store := newStore(db, WithTracerProvider(tp), WithFeedThreshold(1), WithNotifier(NewNotifier()))
+	err = store.ProcessEventContext(ctx, &goes.Event{Source: "agg1", Version: 3, TypeCode: "foo"})
+	assert.Nil(t, err)
+	parent.End()
+
+	spans := make(map[string]tracetest.SpanStub)
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+
+	for _, name := range []string{"sqlLockTable", "sqlLatestFeedId", "sqlInsertEventIntoFeed",
+		"sqlRecentFeedCount", "createNewFeed", "sqlUpdateFeedIds", "sqlInsertFeed"} {
+		if assert.Contains(t, spans, name) {
+			assert.True(t, spans[name].SpanContext.TraceID() == parent.SpanContext().TraceID())
+		}
+	}
+
+	process := spans["processEvent"]
+	assert.Equal(t, parent.SpanContext().SpanID(), process.Parent.SpanID())
+	assert.Equal(t, "agg1", spanAttribute(process, AttributeAggregateID))
+	assert.Equal(t, "foo", spanAttribute(process, AttributeTypeCode))
+	assert.NotEqual(t, "", spanAttribute(process, AttributeFeedID))
+	assert.Equal(t, spanAttribute(process, AttributeFeedID), spanAttribute(spans["createNewFeed"], AttributeFeedID))
+	assert.Equal(t, process.SpanContext.SpanID(), spans["sqlLockTable"].Parent.SpanID())
+	assert.Equal(t, spans["createNewFeed"].SpanContext.SpanID(), spans["sqlInsertFeed"].Parent.SpanID())
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestRetrieveSpans(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	rows := sqlmock.NewRows([]string{"id", "feedid", "event_time", "typecode", "payload"}).
+		AddRow(int64(7), "feed-1", time.Now(), "foo", []byte("ok"))
+	mock.ExpectQuery("select id, feedid").WithArgs("agg1", 1).WillReturnRows(rows)
+	mock.ExpectQuery("select previous").WithArgs("nope").WillReturnRows(sqlmock.NewRows([]string{"previous"}))
+
+	exporter := tracetest.NewInMemoryExporter()
+	store := newStore(db, WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))))
+
+	_, err = store.RetrieveEventContext(context.Background(), "agg1", 1)
+	assert.Nil(t, err)
+
+	_, err = store.RetrievePreviousFeedContext(context.Background(), "nope")
+	assert.Equal(t, ErrFeedNotFound, err)
+
+	spans := exporter.GetSpans()
+	if assert.Equal(t, 2, len(spans)) {
+		assert.Equal(t, "RetrieveEvent", spans[0].Name)
+		assert.Equal(t, "agg1", spanAttribute(spans[0], AttributeAggregateID))
+		assert.Equal(t, "foo", spanAttribute(spans[0], AttributeTypeCode))
+		assert.Equal(t, "feed-1", spanAttribute(spans[0], AttributeFeedID))
+
+		assert.Equal(t, "RetrievePreviousFeed", spans[1].Name)
+		assert.Equal(t, "nope", spanAttribute(spans[1], AttributeFeedID))
+		assert.Equal(t, 1, len(spans[1].Events))
+	}
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestTracerProviderFromEnv(t *testing.T) {
+	defer os.Unsetenv("TRACE_EXPORTER")
+
+	os.Setenv("TRACE_EXPORTER", "stdout")
+	tp, err := TracerProviderFromEnv()
+	assert.Nil(t, err)
+	assert.NotNil(t, tp)
+
+	os.Setenv("TRACE_EXPORTER", "carrier-pigeon")
+	_, err = TracerProviderFromEnv()
+	assert.NotNil(t, err)
+}
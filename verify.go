@@ -0,0 +1,474 @@
+package esatompub
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	sqlVerifyFeeds   = `select f.id, f.feedid, f.previous, (select count(*) from t_aeae_atom_event e where e.feedid = f.feedid) from t_aefd_feed f order by f.id`
+	sqlOrphanFeedIds = `select distinct e.feedid from t_aeae_atom_event e where e.feedid is not null and not exists (select 1 from t_aefd_feed f where f.feedid = e.feedid)`
+
+	sqlPageBoundaries = `select min(id), max(id), count(*) from ` +
+		`(select id, ceil(row_number() over (order by id) / :1) page from t_aeae_atom_event) ` +
+		`group by page order by min(id)`
+	sqlAssignPage      = `update t_aeae_atom_event set feedid = :1 where id between :2 and :3`
+	sqlUnassignFrom    = `update t_aeae_atom_event set feedid = null where id >= :1`
+	sqlRepairFeedOrder = `select e.feedid, coalesce(min(f.event_time), max(e.event_time)) from t_aeae_atom_event e ` +
+		`left join t_aefd_feed f on f.feedid = e.feedid where e.feedid is not null group by e.feedid order by min(e.id)`
+	sqlDeleteFeeds       = `delete from t_aefd_feed`
+	sqlInsertFeedCreated = `insert into t_aefd_feed (feedid, previous, event_time) values (:1, :2, :3)`
+)
+
+// AnomalyKind classifies a problem found in the feed chain.
+type AnomalyKind string
+
+const (
+	// AnomalyFork is two or more feeds sharing the same previous feed.
+	AnomalyFork AnomalyKind = "fork"
+
+	// AnomalyDanglingPrevious is a feed whose previous feed doesn't exist.
+	AnomalyDanglingPrevious AnomalyKind = "dangling-previous"
+
+	// AnomalyCycle is a chain of previous links that loops back on itself.
+	AnomalyCycle AnomalyKind = "cycle"
+
+	// AnomalyUnreachable is a feed that can't be reached walking back from the last feed.
+	AnomalyUnreachable AnomalyKind = "unreachable"
+
+	// AnomalyDuplicateFeed is a feed id recorded more than once in the feed table.
+	AnomalyDuplicateFeed AnomalyKind = "duplicate-feed"
+
+	// AnomalyEmptyFeed is a feed holding no events.
+	AnomalyEmptyFeed AnomalyKind = "empty-feed"
+
+	// AnomalyPageSize is an archive feed holding other than FeedThreshold events, or a
+	// recent page holding FeedThreshold events or more.
+	AnomalyPageSize AnomalyKind = "page-size"
+
+	// AnomalyOrphanEvents is events assigned to a feed id missing from the feed table.
+	AnomalyOrphanEvents AnomalyKind = "orphan-events"
+)
+
+// Anomaly describes a single problem found by Verify.
+type Anomaly struct {
+	Kind   AnomalyKind
+	FeedID string
+	Detail string
+}
+
+func (a Anomaly) String() string {
+	return fmt.Sprintf("%s %s: %s", a.Kind, a.FeedID, a.Detail)
+}
+
+// VerifyReport is the result of verifying the feed chain.
+type VerifyReport struct {
+	// LastFeed is the feed the walk started from, as returned by RetrieveLastFeed.
+	LastFeed string
+
+	// ChainLength is the number of feeds visited walking back from LastFeed.
+	ChainLength int
+
+	// Feeds is the number of rows in the feed table.
+	Feeds int
+
+	Anomalies []Anomaly
+}
+
+// OK reports whether no anomalies were found.
+func (r VerifyReport) OK() bool {
+	return len(r.Anomalies) == 0
+}
+
+// RepairOptions selects what Repair changes.
+type RepairOptions struct {
+	// Repage reassigns events to feeds so that every archive holds exactly the feed
+	// threshold of events, with the remainder on the recent page. Existing feed ids
+	// are reused in order, but the events under an archive feed id can change.
+	Repage bool
+
+	// Caches are purged once a repair is committed, as the pages and links they hold
+	// may have changed. Caches in other processes must be purged by their owners.
+	Caches []*ArchiveCache
+}
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// tableQueryer substitutes the store's table names into the queries run on q.
+type tableQueryer struct {
+	q queryer
+	s *Store
+}
+
+func (t tableQueryer) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return t.q.QueryContext(ctx, t.s.sqlFor(query), args...)
+}
+
+type feedRow struct {
+	id       int64
+	feedid   string
+	previous sql.NullString
+	count    int
+}
+
+// Verify walks the feed chain from the last feed back to the first, reporting every
+// anomaly found.
+func Verify(db *sql.DB) (VerifyReport, error) {
+	return VerifyContext(context.Background(), db)
+}
+
+// VerifyContext is like Verify, but the queries are bound to ctx.
+func VerifyContext(ctx context.Context, db *sql.DB) (VerifyReport, error) {
+	return newStore(db).VerifyContext(ctx)
+}
+
+// VerifyContext walks the feed chain from the last feed back to the first, reporting
+// forks, dangling previous links, cycles, unreachable and duplicate feeds, empty
+// feeds, pages whose size differs from the feed threshold, and events assigned to
+// feeds that don't exist. It reads the primary without locking, so a rollover in
+// progress can be reported as an anomaly; verify again before repairing.
+func (s *Store) VerifyContext(ctx context.Context) (VerifyReport, error) {
+	return s.verify(ctx, s.db)
+}
+
+func (s *Store) verify(ctx context.Context, q queryer) (VerifyReport, error) {
+	var report VerifyReport
+	q = tableQueryer{q: q, s: s}
+
+	feeds, err := selectFeedRows(ctx, q)
+	if err != nil {
+		return report, err
+	}
+	report.Feeds = len(feeds)
+
+	threshold := s.threshold()
+	byFeedID := make(map[string]feedRow)
+	children := make(map[string][]string)
+	for _, feed := range feeds {
+		if _, ok := byFeedID[feed.feedid]; ok {
+			report.add(AnomalyDuplicateFeed, feed.feedid, "feed id appears more than once in the feed table")
+		}
+		byFeedID[feed.feedid] = feed
+
+		if feed.previous.Valid {
+			children[feed.previous.String] = append(children[feed.previous.String], feed.feedid)
+		}
+
+		if feed.count == 0 {
+			report.add(AnomalyEmptyFeed, feed.feedid, "feed holds no events")
+		} else if feed.count != threshold {
+			report.add(AnomalyPageSize, feed.feedid, fmt.Sprintf("feed holds %d events, expected %d", feed.count, threshold))
+		}
+	}
+
+	var shared []string
+	for previous, next := range children {
+		if len(next) > 1 {
+			shared = append(shared, previous)
+		}
+	}
+	sort.Strings(shared)
+	for _, previous := range shared {
+		report.add(AnomalyFork, previous, "previous feed of "+strings.Join(children[previous], ", "))
+	}
+
+	//Walk back from the feed RetrieveLastFeed returns, the one with the highest id
+	visited := make(map[string]bool)
+	if len(feeds) > 0 {
+		report.LastFeed = feeds[len(feeds)-1].feedid
+	}
+
+	for current := report.LastFeed; current != ""; {
+		if visited[current] {
+			report.add(AnomalyCycle, current, "feed chain loops back to this feed")
+			break
+		}
+		visited[current] = true
+		report.ChainLength++
+
+		previous := byFeedID[current].previous
+		if !previous.Valid {
+			break
+		}
+
+		if _, ok := byFeedID[previous.String]; !ok {
+			report.add(AnomalyDanglingPrevious, current, "previous feed "+previous.String+" does not exist")
+			break
+		}
+
+		current = previous.String
+	}
+
+	for _, feed := range feeds {
+		if !visited[feed.feedid] {
+			visited[feed.feedid] = true
+			report.add(AnomalyUnreachable, feed.feedid, "feed is not on the chain ending at "+report.LastFeed)
+		}
+	}
+
+	orphans, err := selectOrphanFeedIds(ctx, q)
+	if err != nil {
+		return report, err
+	}
+	for _, feedid := range orphans {
+		report.add(AnomalyOrphanEvents, feedid, "events are assigned to a feed missing from the feed table")
+	}
+
+	var recent int
+	var lastId sql.NullInt64
+	if err := queryRowScan(ctx, q, sqlRecentFeedCount, &recent, &lastId); err != nil {
+		return report, err
+	}
+	if recent >= threshold {
+		report.add(AnomalyPageSize, "", fmt.Sprintf("recent page holds %d events, expected fewer than %d", recent, threshold))
+	}
+
+	return report, nil
+}
+
+func (r *VerifyReport) add(kind AnomalyKind, feedid string, detail string) {
+	r.Anomalies = append(r.Anomalies, Anomaly{Kind: kind, FeedID: feedid, Detail: detail})
+}
+
+func selectFeedRows(ctx context.Context, q queryer) ([]feedRow, error) {
+	var feeds []feedRow
+
+	rows, err := q.QueryContext(ctx, sqlVerifyFeeds)
+	if err != nil {
+		return feeds, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var feed feedRow
+		if err = rows.Scan(&feed.id, &feed.feedid, &feed.previous, &feed.count); err != nil {
+			return feeds, err
+		}
+
+		feeds = append(feeds, feed)
+	}
+
+	return feeds, rows.Err()
+}
+
+func selectOrphanFeedIds(ctx context.Context, q queryer) ([]string, error) {
+	var feedids []string
+
+	rows, err := q.QueryContext(ctx, sqlOrphanFeedIds)
+	if err != nil {
+		return feedids, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var feedid string
+		if err = rows.Scan(&feedid); err != nil {
+			return feedids, err
+		}
+
+		feedids = append(feedids, feedid)
+	}
+
+	return feedids, rows.Err()
+}
+
+func queryRowScan(ctx context.Context, q queryer, query string, dest ...interface{}) error {
+	rows, err := q.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		return sql.ErrNoRows
+	}
+
+	return rows.Scan(dest...)
+}
+
+// Repair verifies the feed chain under the feed table lock and, if anomalies are
+// found, rebuilds it. The report describes the anomalies found before the repair.
+func Repair(db *sql.DB, opts RepairOptions) (VerifyReport, error) {
+	return RepairContext(context.Background(), db, opts)
+}
+
+// RepairContext is like Repair, but the work is bound to ctx.
+func RepairContext(ctx context.Context, db *sql.DB, opts RepairOptions) (VerifyReport, error) {
+	return newStore(db).RepairContext(ctx, opts)
+}
+
+// RepairContext verifies the feed chain under the feed table lock, the lock the
+// processor holds while writing, and if anomalies are found rebuilds the chain in a
+// single transaction. The feed table is rewritten with one feed per feed id that
+// holds events, linked in the order of their events, which removes forks, dangling
+// links, cycles, duplicates and empty feeds, and records the feeds of orphaned
+// events. With opts.Repage the events are first reassigned to pages of the feed
+// threshold. The caches in opts are purged after a repair. The report describes the
+// anomalies found before the repair.
+func (s *Store) RepairContext(ctx context.Context, opts RepairOptions) (VerifyReport, error) {
+	var report VerifyReport
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return report, err
+	}
+
+	if err = s.lockTable(ctx, tx); err != nil {
+		s.doRollback(tx)
+		return report, err
+	}
+
+	report, err = s.verify(ctx, tx)
+	if err != nil {
+		s.doRollback(tx)
+		return report, err
+	}
+
+	if report.OK() {
+		return report, tx.Commit()
+	}
+
+	s.logger.Warn("Repairing feed chain", "anomalies", len(report.Anomalies))
+	for _, anomaly := range report.Anomalies {
+		s.logger.Warn("Repairing anomaly", "kind", string(anomaly.Kind), LogFieldFeedID, anomaly.FeedID, "detail", anomaly.Detail)
+	}
+
+	if opts.Repage {
+		if err = s.repage(ctx, tx); err != nil {
+			s.doRollback(tx)
+			return report, err
+		}
+	}
+
+	if err = s.relink(ctx, tx); err != nil {
+		s.doRollback(tx)
+		return report, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return report, err
+	}
+
+	for _, cache := range opts.Caches {
+		cache.Purge()
+	}
+
+	return report, nil
+}
+
+type feedOrder struct {
+	feedid  string
+	created time.Time
+}
+
+func (s *Store) selectFeedOrder(ctx context.Context, tx *sql.Tx) ([]feedOrder, error) {
+	var feeds []feedOrder
+
+	rows, err := s.queryTx(ctx, tx, sqlRepairFeedOrder)
+	if err != nil {
+		return feeds, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var feed feedOrder
+		if err = rows.Scan(&feed.feedid, &feed.created); err != nil {
+			return feeds, err
+		}
+
+		feeds = append(feeds, feed)
+	}
+
+	return feeds, rows.Err()
+}
+
+// repage reassigns the events to full pages of the feed threshold in id order, reusing
+// the existing feed ids in order, and returns the remainder to the recent page.
+func (s *Store) repage(ctx context.Context, tx *sql.Tx) error {
+	existing, err := s.selectFeedOrder(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	type page struct {
+		first, last int64
+		count       int
+	}
+
+	var pages []page
+	rows, err := s.queryTx(ctx, tx, sqlPageBoundaries, s.threshold())
+	if err != nil {
+		return err
+	}
+
+	for rows.Next() {
+		var p page
+		if err = rows.Scan(&p.first, &p.last, &p.count); err != nil {
+			rows.Close()
+			return err
+		}
+		pages = append(pages, p)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for i, p := range pages {
+		if p.count < s.threshold() {
+			_, err = s.execTx(ctx, tx, sqlUnassignFrom, p.first)
+			return err
+		}
+
+		var feedid string
+		if i < len(existing) {
+			feedid = existing[i].feedid
+		} else if feedid, err = uuid(); err != nil {
+			return err
+		}
+
+		if _, err = s.execTx(ctx, tx, sqlAssignPage, feedid, p.first, p.last); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// relink rewrites the feed table with the feeds that hold events, linked in the order
+// of their events. The feed table id is an identity column, so the rows are inserted
+// in chain order to keep the last feed the one with the highest id.
+func (s *Store) relink(ctx context.Context, tx *sql.Tx) error {
+	feeds, err := s.selectFeedOrder(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	if _, err = s.execTx(ctx, tx, sqlDeleteFeeds); err != nil {
+		return err
+	}
+
+	var previous sql.NullString
+	for _, feed := range feeds {
+		if _, err = s.execTx(ctx, tx, sqlInsertFeedCreated, feed.feedid, previous, feed.created); err != nil {
+			return err
+		}
+		previous = sql.NullString{String: feed.feedid, Valid: true}
+	}
+
+	return nil
+}
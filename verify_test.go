@@ -0,0 +1,178 @@
+package esatompub
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"testing"
+	"time"
+)
+
+var verifyFeedColumns = []string{"id", "feedid", "previous", "count"}
+
+func expectVerifyQueries(mock sqlmock.Sqlmock, feeds *sqlmock.Rows, orphans *sqlmock.Rows, recent int) {
+	mock.ExpectQuery("from t_aefd_feed f order by f.id").WillReturnRows(feeds)
+	mock.ExpectQuery("select distinct e.feedid").WillReturnRows(orphans)
+	mock.ExpectQuery("select count").WillReturnRows(sqlmock.NewRows([]string{"count(*)", "max(id)"}).AddRow(recent, nil))
+}
+
+func anomalyKinds(report VerifyReport) map[AnomalyKind][]string {
+	kinds := make(map[AnomalyKind][]string)
+	for _, anomaly := range report.Anomalies {
+		kinds[anomaly.Kind] = append(kinds[anomaly.Kind], anomaly.FeedID)
+	}
+	return kinds
+}
+
+func TestVerifyReportsAnomalies(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	feeds := sqlmock.NewRows(verifyFeedColumns).
+		AddRow(1, "f1", nil, 2).
+		AddRow(2, "f2", "f1", 2).
+		AddRow(3, "f3", "f1", 1).
+		AddRow(4, "f4", "f3", 0)
+	expectVerifyQueries(mock, feeds, sqlmock.NewRows([]string{"feedid"}).AddRow("f9"), 2)
+
+	report, err := newStore(db, WithFeedThreshold(2)).VerifyContext(context.Background())
+	if assert.Nil(t, err) {
+		assert.False(t, report.OK())
+		assert.Equal(t, "f4", report.LastFeed)
+		assert.Equal(t, 3, report.ChainLength)
+		assert.Equal(t, 4, report.Feeds)
+
+		kinds := anomalyKinds(report)
+		assert.Equal(t, []string{"f1"}, kinds[AnomalyFork])
+		assert.Equal(t, []string{"f2"}, kinds[AnomalyUnreachable])
+		assert.Equal(t, []string{"f4"}, kinds[AnomalyEmptyFeed])
+		assert.Equal(t, []string{"f3", ""}, kinds[AnomalyPageSize])
+		assert.Equal(t, []string{"f9"}, kinds[AnomalyOrphanEvents])
+	}
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestVerifyDanglingAndCycle(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	feeds := sqlmock.NewRows(verifyFeedColumns).
+		AddRow(1, "f1", "f2", 2).
+		AddRow(2, "f2", "f1", 2).
+		AddRow(3, "f3", "gone", 2)
+	expectVerifyQueries(mock, feeds, sqlmock.NewRows([]string{"feedid"}), 0)
+
+	report, err := Verify(db)
+	if assert.Nil(t, err) {
+		kinds := anomalyKinds(report)
+		assert.Equal(t, []string{"f3"}, kinds[AnomalyDanglingPrevious])
+		assert.Equal(t, []string{"f1", "f2"}, kinds[AnomalyUnreachable])
+	}
+
+	feeds = sqlmock.NewRows(verifyFeedColumns).
+		AddRow(1, "f1", "f2", 2).
+		AddRow(2, "f2", "f1", 2)
+	expectVerifyQueries(mock, feeds, sqlmock.NewRows([]string{"feedid"}), 0)
+
+	report, err = newStore(db, WithFeedThreshold(2)).VerifyContext(context.Background())
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"f2"}, anomalyKinds(report)[AnomalyCycle])
+	}
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestRepairHealthyChainMakesNoChanges(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("lock table t_aefd_feed").WillReturnResult(sqlmock.NewResult(0, 0))
+	expectVerifyQueries(mock, sqlmock.NewRows(verifyFeedColumns).AddRow(1, "f1", nil, 2), sqlmock.NewRows([]string{"feedid"}), 1)
+	mock.ExpectCommit()
+
+	report, err := newStore(db, WithFeedThreshold(2)).RepairContext(context.Background(), RepairOptions{})
+	assert.Nil(t, err)
+	assert.True(t, report.OK())
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestRepairRelinks(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	created := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("lock table t_aefd_feed").WillReturnResult(sqlmock.NewResult(0, 0))
+	feeds := sqlmock.NewRows(verifyFeedColumns).
+		AddRow(1, "f1", nil, 2).
+		AddRow(2, "f2", "f1", 2).
+		AddRow(3, "f3", "f1", 2).
+		AddRow(4, "f4", "f3", 0)
+	expectVerifyQueries(mock, feeds, sqlmock.NewRows([]string{"feedid"}), 0)
+	mock.ExpectQuery("group by e.feedid order by min").WillReturnRows(sqlmock.NewRows([]string{"feedid", "created"}).
+		AddRow("f1", created).AddRow("f2", created).AddRow("f3", created))
+	mock.ExpectExec("delete from t_aefd_feed").WillReturnResult(sqlmock.NewResult(0, 4))
+	mock.ExpectExec("insert into t_aefd_feed").WithArgs("f1", nil, created).WillReturnResult(sqlmock.NewResult(5, 1))
+	mock.ExpectExec("insert into t_aefd_feed").WithArgs("f2", "f1", created).WillReturnResult(sqlmock.NewResult(6, 1))
+	mock.ExpectExec("insert into t_aefd_feed").WithArgs("f3", "f2", created).WillReturnResult(sqlmock.NewResult(7, 1))
+	mock.ExpectCommit()
+
+	report, err := newStore(db, WithFeedThreshold(2)).RepairContext(context.Background(), RepairOptions{})
+	assert.Nil(t, err)
+	assert.False(t, report.OK())
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestRepairRepages(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	created := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("lock table t_aefd_feed").WillReturnResult(sqlmock.NewResult(0, 0))
+	expectVerifyQueries(mock, sqlmock.NewRows(verifyFeedColumns).AddRow(1, "f1", nil, 3), sqlmock.NewRows([]string{"feedid"}), 0)
+	mock.ExpectQuery("group by e.feedid order by min").WillReturnRows(sqlmock.NewRows([]string{"feedid", "created"}).AddRow("f1", created))
+	mock.ExpectQuery("row_number").WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"min(id)", "max(id)", "count(*)"}).
+		AddRow(10, 11, 2).AddRow(12, 12, 1))
+	mock.ExpectExec("update t_aeae_atom_event set feedid = :1").WithArgs("f1", 10, 11).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("update t_aeae_atom_event set feedid = null").WithArgs(12).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("group by e.feedid order by min").WillReturnRows(sqlmock.NewRows([]string{"feedid", "created"}).AddRow("f1", created))
+	mock.ExpectExec("delete from t_aefd_feed").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("insert into t_aefd_feed").WithArgs("f1", nil, created).WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+
+	cache := NewCachingReader(&cachedArchiveReader{}, 10)
+	cache.RetrieveArchiveContext(context.Background(), "f1")
+	assert.Equal(t, 1, cache.Len())
+
+	_, err = newStore(db, WithFeedThreshold(2)).RepairContext(context.Background(), RepairOptions{Repage: true, Caches: []*ArchiveCache{cache}})
+	assert.Nil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+	assert.Equal(t, 0, cache.Len())
+}
+
+// cachedArchiveReader serves an empty archive for any feed.
+type cachedArchiveReader struct {
+	Reader
+}
+
+func (r *cachedArchiveReader) RetrieveArchiveContext(ctx context.Context, feedid string) ([]TimestampedEvent, error) {
+	return nil, nil
+}